@@ -0,0 +1,64 @@
+// Streams that don't block the reader.
+
+package roomba
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// BufferedStream is a stream started by StreamBuffered.
+type BufferedStream struct {
+	dropped uint64 // Accessed atomically, first for alignment on 32-bit.
+
+	// Frames receives the buffered frames. It's closed when the stream is
+	// stopped.
+	Frames <-chan [][]byte
+}
+
+// Dropped returns the number of frames dropped so far because the buffer was
+// full.
+func (s *BufferedStream) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// StreamBuffered starts a stream like Stream, but buffers up to bufSize frames
+// for a slow consumer instead of making the reader wait, which would let the
+// port back up until frames are corrupted. When the buffer is full, the oldest
+// frame is dropped if dropOldest is set, and the newest one otherwise. The
+// stream is stopped with StopStream.
+func (roomba *Roomba) StreamBuffered(packetIds []constants.SensorCode, bufSize int, dropOldest bool) (*BufferedStream, error) {
+	if bufSize < 1 {
+		return nil, fmt.Errorf("invalid buffer size: %d", bufSize)
+	}
+	frames, err := roomba.Stream(packetIds)
+	if err != nil {
+		return nil, err
+	}
+	buf := make(chan [][]byte, bufSize)
+	s := &BufferedStream{Frames: buf}
+	go func() {
+		defer close(buf)
+		for frame := range frames {
+			select {
+			case buf <- frame:
+				continue
+			default:
+			}
+			atomic.AddUint64(&s.dropped, 1)
+			if !dropOldest {
+				continue
+			}
+			// Only this goroutine sends, so there's room once a frame
+			// is taken, either here or by the consumer.
+			select {
+			case <-buf:
+			default:
+			}
+			buf <- frame
+		}
+	}()
+	return s, nil
+}
@@ -0,0 +1,51 @@
+package roomba_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestStreamBuffered(t *testing.T) {
+	for _, dropOldest := range []bool{false, true} {
+		r := rt.MakeTestRoomba()
+		var values [][]byte
+		for i := 1; i <= 100; i++ {
+			values = append(values, []byte{byte(i)})
+		}
+		rt.SetSensorSequence(constants.SENSOR_VIRTUAL_WALL, values)
+
+		s, err := r.StreamBuffered([]constants.SensorCode{constants.SENSOR_VIRTUAL_WALL}, 2, dropOldest)
+		if err != nil {
+			t.Fatalf("error starting stream: %s", err)
+		}
+		// Let about 20 frames arrive without receiving any.
+		time.Sleep(300 * time.Millisecond)
+		dropped := s.Dropped()
+		if dropped < 10 {
+			t.Errorf("dropOldest %v: %d frames dropped, expected at least 10", dropOldest, dropped)
+		}
+		first, second := (<-s.Frames)[0][0], (<-s.Frames)[0][0]
+		if !dropOldest && (first != 1 || second != 2) {
+			t.Errorf("kept frames %d and %d, expected the oldest 1 and 2", first, second)
+		}
+		if dropOldest && (first < 10 || second <= first) {
+			t.Errorf("kept frames %d and %d, expected recent ones in order", first, second)
+		}
+
+		r.StopStream()
+		for range s.Frames {
+		}
+		rt.ClearTestRoomba()
+	}
+}
+
+func TestStreamBufferedInvalidSize(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	if _, err := r.StreamBuffered([]constants.SensorCode{constants.SENSOR_WALL}, 0, true); err == nil {
+		t.Errorf("StreamBuffered accepted a buffer size of 0")
+	}
+}
@@ -22,13 +22,39 @@ package roomba
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/infinities-within/go-roomba/constants"
 )
 
+// resetPollInterval is the time between OI mode queries in ResetAndWait.
+const resetPollInterval = 500 * time.Millisecond
+
+// pingTimeout is how long Ping waits for a reply.
+const pingTimeout = 500 * time.Millisecond
+
+// streamDrainPeriod is how long the port must be quiet after pausing a stream
+// before frames sent in the meantime are considered discarded. It's longer
+// than the 15 ms between frames.
+const streamDrainPeriod = 50 * time.Millisecond
+
+// rampInterval is the time between duty cycle changes in RampLowSideDriver.
+const rampInterval = 20 * time.Millisecond
+
+func clamp16(v, min, max int16) int16 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
 func toByte(b bool) byte {
 	if b {
 		return 1
@@ -40,11 +66,42 @@ func toByte(b bool) byte {
 // By default, Roomba communicates at 57600 baud.
 func MakeRoomba(portName string) (*Roomba, error) {
 	roomba := &Roomba{PortName: portName, StreamPaused: make(chan bool, 1)}
-	baud := uint(57600)
+	err := roomba.Open(defaultBaud)
+	return roomba, err
+}
+
+// MakeRoombaReadOnly sets up a serial port like MakeRoomba, but the returned
+// Roomba can only read, e.g. to monitor the stream requested by another
+// controller on the same line with ReadStream.
+func MakeRoombaReadOnly(portName string, baud uint) (*Roomba, error) {
+	roomba := &Roomba{PortName: portName, StreamPaused: make(chan bool, 1), ReadOnly: true}
 	err := roomba.Open(baud)
 	return roomba, err
 }
 
+// MakeRoombaRetry sets up a serial port like MakeRoomba, but retries opening
+// it until it succeeds or attempts are exhausted, e.g. while the device node
+// doesn't exist yet on boot. It tries at least once. The wait between attempts
+// starts at backoff and doubles after each failure.
+func MakeRoombaRetry(portName string, baud uint, attempts int, backoff time.Duration) (*Roomba, error) {
+	roomba := &Roomba{PortName: portName, StreamPaused: make(chan bool, 1)}
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			log.Printf("retrying to open %s in %v", portName, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = roomba.Open(baud); err == nil {
+			return roomba, nil
+		}
+	}
+	return roomba, fmt.Errorf("failed to open %s after %d attempts: %w", portName, attempts, err)
+}
+
 // Start command starts the OI. You must always send the Start command before
 // sending any other commands to the OI.
 // Note: Use the Start command (128) to change the mode to Passive.
@@ -52,7 +109,49 @@ func (roomba *Roomba) Start() error {
 	return roomba.WriteByte(constants.Start)
 }
 
-// TODO: Baud command.
+// Reset command reboots the robot as if the battery had been removed. The
+// reboot takes several seconds and leaves the OI in Off mode, so callers must
+// send Start again afterwards.
+func (roomba *Roomba) Reset() error {
+	return roomba.WriteByte(constants.Reset)
+}
+
+// ResetAndWait sends Reset and waits until the robot answers an OI mode query
+// again, or until ctx is done. The robot ignores input while it boots, so
+// each query is given up after resetPollInterval and sent again; one query is
+// pending at a time, and a late reply is discarded like in SensorsContext. A
+// query failing right away, e.g. because the port can't be written, is sent
+// again after the rest of resetPollInterval. As with Reset, the OI is left in
+// Off mode.
+func (roomba *Roomba) ResetAndWait(ctx context.Context) error {
+	if err := roomba.Reset(); err != nil {
+		return err
+	}
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, resetPollInterval)
+		_, err := roomba.SensorsContext(attemptCtx, constants.SENSOR_OI_MODE)
+		if err == nil {
+			cancel()
+			return nil
+		}
+		<-attemptCtx.Done()
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Baud command sets the baud rate of the OI, which must be one of the rates
+// supported by BaudCode. The port isn't reopened at the new rate, so commands
+// sent afterwards are lost until it is; SetBaud does both.
+func (roomba *Roomba) Baud(baud uint) error {
+	code, err := BaudCode(baud)
+	if err != nil {
+		return err
+	}
+	return roomba.Write(constants.Baud, []byte{code})
+}
 
 // Passive switches Roomba to passive mode by sending the Start command.
 func (roomba *Roomba) Passive() error {
@@ -60,26 +159,88 @@ func (roomba *Roomba) Passive() error {
 }
 
 // This command puts the OI into Safe mode, enabling user control of Roomba.
-// It turns off all LEDs.
+// It turns off all LEDs. It re-enables drive commands after EmergencyStop.
 func (roomba *Roomba) Safe() error {
-	return roomba.WriteByte(constants.Safe)
+	return roomba.enableDrive(constants.Safe)
 }
 
 // Full command gives you complete control over Roomba by putting the OI into
 // Full mode, and turning off the cliff, wheel-drop and internal charger safety
-// features.
+// features. It re-enables drive commands after EmergencyStop.
 func (roomba *Roomba) Full() error {
-	return roomba.WriteByte(constants.Full)
+	return roomba.enableDrive(constants.Full)
 }
 
-// Control command's effect and usage are identical to the Safe command.
+// Control command's effect and usage are identical to the Safe command. It's
+// kept for the original Create, whose OI documented it. The OI accepts it in
+// Passive, Safe and Full modes, so Start is sent first, like the "128 130"
+// sequence of the Create examples; this also makes it work when the OI is
+// off.
 func (roomba *Roomba) Control() error {
-	roomba.Passive()
-	return roomba.WriteByte(constants.Control) // ?
+	if err := roomba.Passive(); err != nil {
+		return err
+	}
+	return roomba.enableDrive(constants.Control)
+}
+
+// WaitForMode polls the OI mode every poll until it's mode or ctx is done.
+// Mode changes take a moment on the robot, so commands sent right after e.g.
+// Safe may otherwise be handled in the previous mode.
+func (roomba *Roomba) WaitForMode(ctx context.Context, mode OIMode, poll time.Duration) error {
+	return roomba.waitFor(ctx, constants.SENSOR_OI_MODE, poll, func(current []byte) bool {
+		return OIMode(current[0]) == mode
+	})
+}
+
+// enableDrive writes a mode command that allows driving and clears the guard
+// set by EmergencyStop.
+func (roomba *Roomba) enableDrive(opcode constants.OpCode) error {
+	if err := roomba.WriteByte(opcode); err != nil {
+		return err
+	}
+	roomba.setStopped(false)
+	if opcode != constants.Full {
+		roomba.ledMu.Lock()
+		roomba.leds = ledState{}
+		roomba.ledMu.Unlock()
+	}
+	return nil
+}
+
+func (roomba *Roomba) setStopped(stopped bool) {
+	roomba.mu.Lock()
+	roomba.stopped = stopped
+	roomba.mu.Unlock()
+}
+
+// checkStopped returns an error wrapping ErrStopped if drive commands are
+// disabled by EmergencyStop.
+func (roomba *Roomba) checkStopped() error {
+	roomba.mu.Lock()
+	defer roomba.mu.Unlock()
+	if roomba.stopped {
+		return fmt.Errorf("drive command: %w; call Safe or Full first", ErrStopped)
+	}
+	return nil
+}
+
+// startBeforeCleaning sends Start if StartBeforeCleaning is set and Start
+// wasn't sent yet.
+func (roomba *Roomba) startBeforeCleaning() error {
+	roomba.mu.Lock()
+	started := roomba.started
+	roomba.mu.Unlock()
+	if !roomba.StartBeforeCleaning || started {
+		return nil
+	}
+	return roomba.Start()
 }
 
 // Clean command starts the default cleaning mode.
 func (roomba *Roomba) Clean() error {
+	if err := roomba.startBeforeCleaning(); err != nil {
+		return err
+	}
 	return roomba.WriteByte(constants.Cover)
 }
 
@@ -87,14 +248,57 @@ func (roomba *Roomba) Clean() error {
 
 // Spot command starts the Spot cleaning mode.
 func (roomba *Roomba) Spot() error {
+	if err := roomba.startBeforeCleaning(); err != nil {
+		return err
+	}
 	return roomba.WriteByte(constants.Spot)
 }
 
+// demoAbort is the Demo command's argument that stops the running demo.
+const demoAbort = 255
+
+// Demo command starts one of the built-in demos of Create, in range 0-9. The
+// OI goes to Passive mode. See StopDemo for stopping it. Create only.
+func (roomba *Roomba) Demo(n byte) error {
+	if err := roomba.requireCreate(constants.Demo); err != nil {
+		return err
+	}
+	if n > 9 {
+		return fmt.Errorf("invalid demo: %d", n)
+	}
+	return roomba.Write(constants.Demo, []byte{n})
+}
+
+// StopDemo stops the running demo by sending Demo with -1, 255 as a byte. The
+// OI stays in Passive mode. Create only.
+func (roomba *Roomba) StopDemo() error {
+	if err := roomba.requireCreate(constants.Demo); err != nil {
+		return err
+	}
+	return roomba.Write(constants.Demo, []byte{demoAbort})
+}
+
 // SeekDock command sends Roomba to the dock.
 func (roomba *Roomba) SeekDock() error {
+	if err := roomba.startBeforeCleaning(); err != nil {
+		return err
+	}
 	return roomba.WriteByte(constants.Dock)
 }
 
+// StopOI stops the OI, which goes to Off mode, ending the session. Unlike
+// Stop, which only stops the wheels, the robot then ignores all commands
+// except Start. Create 2 and 600-series Roombas only.
+func (roomba *Roomba) StopOI() error {
+	return roomba.WriteByte(constants.Stop)
+}
+
+// Power command powers down Roomba. The OI is put into Passive mode. Roomba
+// only.
+func (roomba *Roomba) Power() error {
+	return roomba.WriteByte(constants.Power)
+}
+
 // Drive command controls Roomba’s drive wheels. It takes two 16-bit signed
 // values. The first one specifies the average velocity of the drive wheels in
 // millimeters per second (mm/s).  The next one specifies the radius in
@@ -107,20 +311,86 @@ func (roomba *Roomba) SeekDock() error {
 // or drive straight. A negative velocity makes Roomba drive backward. Velocity
 // is in range (-500 – 500 mm/s), radius (-2000 – 2000 mm). Special cases:
 // straight = 32768 or 32767 = hex 8000 or 7FFF, turn in place clockwise = -1,
-// turn in place counter-clockwise = 1
+// turn in place counter-clockwise = 1, see RadiusStraight, RadiusTurnCW and
+// RadiusTurnCCW. Out of range values are an error, or clamped if
+// ClampDriveInputs is set.
+//
+// After EmergencyStop, Drive returns an error wrapping ErrStopped until Safe
+// or Full is called.
 func (roomba *Roomba) Drive(velocity, radius int16) error {
+	if err := roomba.checkStopped(); err != nil {
+		return err
+	}
+	if err := roomba.checkMode(constants.Drive); err != nil {
+		return err
+	}
+	if roomba.ClampDriveInputs {
+		velocity = clamp16(velocity, -500, 500)
+		switch radius {
+		case RadiusStraight, radiusStraightAlt:
+		default:
+			radius = clamp16(radius, -2000, 2000)
+		}
+	}
+	b, err := EncodeDrive(velocity, radius)
+	if err != nil {
+		return err
+	}
+	return roomba.Write(constants.Drive, b[1:])
+}
+
+// Special cases of the Drive radius.
+const (
+	RadiusStraight int16 = 32767
+	RadiusTurnCW   int16 = -1
+	RadiusTurnCCW  int16 = 1
+
+	// radiusStraightAlt also drives straight. It's 32768, hex 8000, in
+	// two's complement.
+	radiusStraightAlt int16 = -32768
+)
+
+// EncodeDrive returns the bytes of a Drive command, opcode included, without
+// sending it, e.g. for building scripts. The arguments are checked like in
+// Drive.
+func EncodeDrive(velocity, radius int16) ([]byte, error) {
 	if !(-500 <= velocity && velocity <= 500) {
-		return fmt.Errorf("invalid velocity: %d", velocity)
+		return nil, fmt.Errorf("invalid velocity: %d", velocity)
 	}
-	if !(-2000 <= radius && radius <= 2000) {
-		return fmt.Errorf("invalid readius: %d", radius)
+	switch radius {
+	case RadiusStraight, radiusStraightAlt, RadiusTurnCW, RadiusTurnCCW:
+	default:
+		if !(-2000 <= radius && radius <= 2000) {
+			return nil, fmt.Errorf("invalid readius: %d", radius)
+		}
 	}
-	return roomba.Write(constants.Drive, Pack([]interface{}{velocity, radius}))
+	return Pack([]interface{}{constants.Drive, velocity, radius}), nil
 }
 
-// Stop commands is equivalent to Drive(0, 0).
+// Stop commands is equivalent to Drive(0, 0). It only stops the wheels, see
+// StopOI for stopping the OI. Unlike Drive, it's allowed after EmergencyStop.
 func (roomba *Roomba) Stop() error {
-	return roomba.Drive(0, 0)
+	return roomba.Command(constants.Drive, int16(0), int16(0))
+}
+
+// EmergencyStop stops the drive wheels and the cleaning motors, and then puts
+// the OI into Passive mode, where actuator commands are ignored. Unlike Stop,
+// the robot can't be driven again until it's put back into Safe or Full mode,
+// so Drive and DirectDrive return ErrStopped until then. All steps are
+// attempted even if one fails, and the first error is returned.
+func (roomba *Roomba) EmergencyStop() error {
+	roomba.setStopped(true)
+	errs := []error{
+		roomba.Command(constants.Drive, int16(0), int16(0)),
+		roomba.Command(constants.Motors, byte(0)),
+		roomba.Passive(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // DirectDrive command lets you control the forward and backward motion of
@@ -129,16 +399,143 @@ func (roomba *Roomba) Stop() error {
 // (mm/s), The next one specifies the velocity of the left wheel A positive
 // velocity makes that wheel drive forward, while a negative velocity makes it
 // drive backward. Right wheel velocity (-500 – 500 mm/s). Left wheel velocity
-// (-500 – 500 mm/s).
+// (-500 – 500 mm/s). Like Drive, it returns ErrStopped after EmergencyStop.
 func (roomba *Roomba) DirectDrive(right, left int16) error {
+	if err := roomba.checkStopped(); err != nil {
+		return err
+	}
+	if err := roomba.checkMode(constants.DriveDirect); err != nil {
+		return err
+	}
+	if roomba.ClampDriveInputs {
+		right, left = clamp16(right, -500, 500), clamp16(left, -500, 500)
+	}
+	b, err := EncodeDirectDrive(right, left)
+	if err != nil {
+		return err
+	}
+	return roomba.Write(constants.DriveDirect, b[1:])
+}
+
+// EncodeDirectDrive returns the bytes of a DirectDrive command, opcode
+// included, without sending it. The arguments are checked like in
+// DirectDrive.
+func EncodeDirectDrive(right, left int16) ([]byte, error) {
 	if !(-500 <= right && right <= 500) ||
 		!(-500 <= left && left <= 500) {
-		return fmt.Errorf("invalid velocity. one of %d or %d", right, left)
+		return nil, fmt.Errorf("invalid velocity. one of %d or %d", right, left)
 	}
-	return roomba.Write(constants.DriveDirect, Pack([]interface{}{right, left}))
+	return Pack([]interface{}{constants.DriveDirect, right, left}), nil
 }
 
-// TODO: Drive PWM, Motors, PWM Motors commands.
+// DrivePWM command controls the raw forward and backward motion of the drive
+// wheels of Roomba 600 and Create 2 robots independently. It takes two 16-bit
+// signed values, the PWM duty cycles of the right and the left wheel, in range
+// (-255 – 255). A positive value makes that wheel drive forward. Like Drive, it
+// returns ErrStopped after EmergencyStop.
+func (roomba *Roomba) DrivePWM(right, left int16) error {
+	if err := roomba.checkStopped(); err != nil {
+		return err
+	}
+	if err := roomba.checkMode(constants.DrivePWM); err != nil {
+		return err
+	}
+	if !(-255 <= right && right <= 255) ||
+		!(-255 <= left && left <= 255) {
+		return fmt.Errorf("invalid PWM. one of %d or %d", right, left)
+	}
+	return roomba.Command(constants.DrivePWM, right, left)
+}
+
+// Motors command controls the cleaning motors of Roomba. Bit 0 turns on the
+// side brush, bit 1 the vacuum and bit 2 the main brush. Setting bits 3 and 4
+// reverses the side brush and the main brush. On Create, the same opcode
+// controls the low side drivers instead, see LowSideDrivers.
+func (roomba *Roomba) Motors(motors byte) error {
+	if err := roomba.checkMode(constants.Motors); err != nil {
+		return err
+	}
+	return roomba.Write(constants.Motors, []byte{motors})
+}
+
+// roombaOI reports whether the declared model has the Roomba Open Interface,
+// which lacks the Create-only commands and stores fewer songs.
+func (roomba *Roomba) roombaOI() bool {
+	return (roomba.Model == ModelRoomba500 || roomba.Model == ModelCreate2) &&
+		!roomba.ForceUnsupported
+}
+
+// requireCreate returns an error if the Create-only command isn't supported
+// by the declared model.
+func (roomba *Roomba) requireCreate(opcode constants.OpCode) error {
+	if roomba.roombaOI() {
+		return fmt.Errorf("%v is unsupported on this model", opcode)
+	}
+	return nil
+}
+
+// LowSideDrivers command lets you control the three low side drivers of
+// Create. The state of each driver is specified by one bit in the data byte:
+// bit 0 is driver 0, bit 1 is driver 1 and bit 2 is driver 2.
+// Create only.
+func (roomba *Roomba) LowSideDrivers(drivers byte) error {
+	if err := roomba.requireCreate(constants.LowSideDrivers); err != nil {
+		return err
+	}
+	return roomba.Write(constants.LowSideDrivers, []byte{drivers})
+}
+
+// RampLowSideDriver changes the PWM duty cycle of low side driver pin (0-2)
+// from one value to another in steps of rampInterval spread over d. Duty
+// cycles range from 0 to 128 (100%). The other drivers are turned off.
+// Create only.
+func (roomba *Roomba) RampLowSideDriver(pin int, from, to byte, d time.Duration) error {
+	if err := roomba.requireCreate(constants.PWMLowSideDrivers); err != nil {
+		return err
+	}
+	if pin < 0 || pin > 2 {
+		return fmt.Errorf("invalid low side driver %d", pin)
+	}
+	if from > 128 || to > 128 {
+		return fmt.Errorf("invalid duty cycle: %d to %d", from, to)
+	}
+	steps := int(d / rampInterval)
+	if steps < 1 {
+		steps = 1
+	}
+	for i := 1; i <= steps; i++ {
+		if i > 1 {
+			time.Sleep(rampInterval)
+		}
+		duty := int(from) + (int(to)-int(from))*i/steps
+		// The data bytes are the duty cycles of drivers 2, 1 and 0.
+		duties := make([]byte, 3)
+		duties[2-pin] = byte(duty)
+		if err := roomba.Write(constants.PWMLowSideDrivers, duties); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DigitalOutputs command controls the state of the three digital output pins
+// on the Create's 25 pin cargo bay connector. Bit 0 is pin 19, bit 1 is pin 7
+// and bit 2 is pin 20. Create only.
+func (roomba *Roomba) DigitalOutputs(outputs byte) error {
+	if err := roomba.requireCreate(constants.DigitalOutputs); err != nil {
+		return err
+	}
+	return roomba.Write(constants.DigitalOutputs, []byte{outputs})
+}
+
+// SendIR command sends the given byte out of low side driver 1, using the
+// format expected by iRobot Create's IR receiver. Create only.
+func (roomba *Roomba) SendIR(b byte) error {
+	if err := roomba.requireCreate(constants.SendIR); err != nil {
+		return err
+	}
+	return roomba.Write(constants.SendIR, []byte{b})
+}
 
 // LEDs command controls the LEDs common to all models of Roomba 500. The
 // Clean/Power LED is specified by two data bytes: one for the color and the
@@ -146,53 +543,108 @@ func (roomba *Roomba) DirectDrive(right, left int16) error {
 // intermediate colors (orange, yellow, etc). Intensitiy: 0 = off, 255 = full
 // intensity. Intermediate values are intermediate intensities.
 func (roomba *Roomba) LEDs(advance, play bool, powerColor, powerIntensity byte) error {
-	var ledBits byte
+	roomba.ledMu.Lock()
+	defer roomba.ledMu.Unlock()
+	return roomba.writeLEDs(ledState{
+		bits:      EncodeLEDBits(advance, play, false, false, false, false),
+		color:     powerColor,
+		intensity: powerIntensity,
+	})
+}
 
-	if advance {
-		ledBits += 8
-	}
-	if play {
-		ledBits += 2
+// Play command plays one of the songs previously stored with the Song
+// command. Song number is in range 0-15 on Create and 0-4 on Roombas and
+// Create 2, see Song. Playing doesn't work while another song is playing,
+// which can be checked with SongState.
+func (roomba *Roomba) Play(songNumber byte) error {
+	if songNumber > roomba.maxSongNumber() {
+		return fmt.Errorf("invalid song number: %d", songNumber)
 	}
+	return roomba.Write(constants.Play, []byte{songNumber})
+}
 
-	return roomba.Write(constants.LEDs, Pack([]interface{}{
-		ledBits, powerColor, powerIntensity}))
+// SongState returns the currently selected song and whether a song is
+// playing, reading both in a single query.
+func (roomba *Roomba) SongState() (number byte, playing bool, err error) {
+	values, err := roomba.QueryList([]constants.SensorCode{
+		constants.SENSOR_SONG_NUMBER,
+		constants.SENSOR_SONG_PLAYING})
+	if err != nil {
+		return 0, false, err
+	}
+	return values[0][0], decodeFlag(values[1][0]), nil
 }
 
 // Sensors command requests the OI to send a packet of sensor data bytes. There
 // are 58 different sensor data packets. Each provides a value of a specific
 // sensor or group of sensors.
+//
+// Sensors and QueryList can be used while a stream is active, e.g. to peek at
+// packets that aren't in the stream. They wait for the frame being read, pause
+// the stream, discard the frames already sent and resume the stream once the
+// reply is read. This makes the read at least streamDrainPeriod (50 ms) slower
+// and the stream skips the frames of that time.
 func (roomba *Roomba) Sensors(packetId constants.SensorCode) ([]byte, error) {
+	return roomba.SensorsContext(context.Background(), packetId)
+}
+
+// SensorsContext works like Sensors, but stops waiting for the reply when ctx
+// is done and returns ctx.Err(). A reply arriving later is discarded before
+// the next Sensors or QueryList request is written, if it arrives within
+// staleReplyQuiet (50 ms) of that request.
+func (roomba *Roomba) SensorsContext(ctx context.Context, packetId constants.SensorCode) ([]byte, error) {
 	bytesToRead, ok := constants.SENSOR_PACKET_LENGTH[packetId]
 	if !ok {
-		return []byte{}, fmt.Errorf("unknown packet id requested: %d", packetId)
+		return []byte{}, &PacketError{packetId, ErrUnknownPacket}
 	}
 
-	roomba.Write(constants.Sensors, []byte{byte(packetId)})
-	var err error
-	var n int
+	if err := roomba.autoStart(); err != nil {
+		return []byte{}, err
+	}
+	roomba.txMu.Lock()
+	defer roomba.txMu.Unlock()
+	resume, err := roomba.pauseStreamForRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer resume()
+	roomba.drainBefore()
+	idle := roomba.idleTime()
+	if err := roomba.Write(constants.Sensors, []byte{byte(packetId)}); err != nil {
+		return []byte{}, err
+	}
 	result := make([]byte, bytesToRead)
-	for byte(n) < bytesToRead {
-		resultView := result[n:]
-		bytesToRead -= byte(n)
-		n, err = roomba.Read(resultView)
-		if err != nil {
-			log.Printf("error %v", err)
-			return result, fmt.Errorf("failed reading sensors data for packet id %d: %s", packetId, err)
+	if err := roomba.readFullContext(ctx, result); err != nil {
+		if err == ctx.Err() {
+			roomba.staleReply = true
+			return nil, err
 		}
+		log.Printf("error %v", err)
+		return result, &PacketError{packetId, checkAsleep(idle, err)}
+	}
+	if err := checkAsleepReply(idle, result); err != nil {
+		return result, &PacketError{packetId, err}
 	}
 	return result, nil
 }
 
+// Ping checks that the robot is responsive by reading its OI mode. It returns
+// an error if the robot doesn't answer within pingTimeout.
+func (roomba *Roomba) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if _, err := roomba.SensorsContext(ctx, constants.SENSOR_OI_MODE); err != nil {
+		return fmt.Errorf("robot didn't respond: %w", err)
+	}
+	return nil
+}
+
 // QueryList command lets you ask for a list of sensor packets. The result is
 // returned once, as in the Sensors command. The robot returns the packets in
 /// the order you specify.
 func (roomba *Roomba) QueryList(packetIds []constants.SensorCode) ([][]byte, error) {
-	for _, packetId := range packetIds {
-		_, ok := constants.SENSOR_PACKET_LENGTH[packetId]
-		if !ok {
-			return [][]byte{}, fmt.Errorf("unknown packet id requested: %d", packetId)
-		}
+	if _, err := PacketsTotalLength(packetIds); err != nil {
+		return [][]byte{}, err
 	}
 
 	b := new(bytes.Buffer)
@@ -200,24 +652,53 @@ func (roomba *Roomba) QueryList(packetIds []constants.SensorCode) ([][]byte, err
 	for _, id := range packetIds {
 		b.WriteByte(byte(id))
 	}
-	roomba.Write(constants.QueryList, b.Bytes())
+	if err := roomba.autoStart(); err != nil {
+		return [][]byte{}, err
+	}
+	roomba.txMu.Lock()
+	defer roomba.txMu.Unlock()
+	resume, err := roomba.pauseStreamForRead(context.Background())
+	if err != nil {
+		return [][]byte{}, err
+	}
+	defer resume()
+	roomba.drainBefore()
+	idle := roomba.idleTime()
+	if err := roomba.Write(constants.QueryList, b.Bytes()); err != nil {
+		return [][]byte{}, err
+	}
 
-	var err error
-	var n int
 	result := make([][]byte, len(packetIds))
 	for i, packetId := range packetIds {
-		bytesToRead := constants.SENSOR_PACKET_LENGTH[packetId]
-		result[i] = make([]byte, bytesToRead)
-		err, n = nil, 0
-		for byte(n) < bytesToRead {
-			resultView := result[i][n:]
-			bytesToRead -= byte(n)
-			n, err = roomba.Read(resultView)
-			if err != nil {
-				return result, fmt.Errorf("failed reading sensors data for packet id %d: %s", packetId, err)
-			}
+		result[i] = make([]byte, constants.SENSOR_PACKET_LENGTH[packetId])
+		if err := roomba.readFull(result[i]); err != nil {
+			return result, &PacketError{packetId, checkAsleep(idle, err)}
 		}
 	}
+	if err := checkAsleepReply(idle, result...); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// QueryMap works like QueryList, but returns the values keyed by packet id.
+// Since the values couldn't all be returned, duplicate ids are an error.
+func (roomba *Roomba) QueryMap(ids []constants.SensorCode) (map[constants.SensorCode][]byte, error) {
+	seen := make(map[constants.SensorCode]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate sensor packet %d", id)
+		}
+		seen[id] = true
+	}
+	values, err := roomba.QueryList(ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[constants.SensorCode][]byte, len(ids))
+	for i, id := range ids {
+		result[id] = values[i]
+	}
 	return result, nil
 }
 
@@ -227,92 +708,280 @@ func (roomba *Roomba) PauseStream() {
 	roomba.StreamPaused <- true
 }
 
-func (roomba *Roomba) ReadStream(packetIds []constants.SensorCode, out chan<- [][]byte) {
-	var dataLength byte
-	for _, packetId := range packetIds {
+// parseFrame splits a single stream frame into the ids and values of its
+// packets, verifying the header, N-bytes and checksum. Group packets are split
+// into the packets they contain.
+func parseFrame(frame []byte) ([]constants.SensorCode, [][]byte, error) {
+	if len(frame) < 3 || frame[0] != 19 {
+		return nil, nil, fmt.Errorf("%w: data doesn't start with header 19", ErrInvalidFrame)
+	}
+	if int(frame[1]) != len(frame)-3 {
+		return nil, nil, fmt.Errorf("%w: N-bytes is %d, expected %d", ErrInvalidFrame,
+			frame[1], len(frame)-3)
+	}
+	// All the bytes of the frame, from the header to the checksum, add up to 0.
+	var sum byte
+	for _, b := range frame {
+		sum += b
+	}
+	if sum != 0 {
+		return nil, nil, fmt.Errorf("%w: computed sum %d", ErrChecksumMismatch, sum)
+	}
+
+	var packetIds []constants.SensorCode
+	var values [][]byte
+	data := frame[2 : len(frame)-1]
+	for len(data) > 0 {
+		packetId := constants.SensorCode(data[0])
 		packetLength, ok := constants.SENSOR_PACKET_LENGTH[packetId]
 		if !ok {
-			log.Printf("unknown packet id requested: %d", packetId)
-			return
+			return nil, nil, &PacketError{packetId, ErrUnknownPacket}
+		}
+		if len(data) < 1+int(packetLength) {
+			return nil, nil, &PacketError{packetId, ErrShortRead}
 		}
-		dataLength += packetLength
+		value := make([]byte, packetLength)
+		copy(value, data[1:])
+		data = data[1+packetLength:]
+		if groupIds, isGroup := constants.SENSOR_GROUP_PACKETS[packetId]; isGroup {
+			split, err := splitGroup(packetId, value)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, id := range groupIds {
+				packetIds = append(packetIds, id)
+				values = append(values, split[id])
+			}
+			continue
+		}
+		packetIds = append(packetIds, packetId)
+		values = append(values, value)
+	}
+	return packetIds, values, nil
+}
+
+// ParseStreamFrame splits a single stream frame, from the 19 header to the
+// checksum, into the values of its packets. It verifies the header, N-bytes,
+// packet lengths and checksum like the stream reader does, so it can be used
+// to process logged frames. Group packets are split like in streams.
+func ParseStreamFrame(frame []byte) (map[constants.SensorCode][]byte, error) {
+	packetIds, values, err := parseFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[constants.SensorCode][]byte, len(packetIds))
+	for i, packetId := range packetIds {
+		result[packetId] = values[i]
+	}
+	return result, nil
+}
+
+// StreamPacketIds returns the ids of the values in the frames of a stream of
+// packetIds. They're the same, except that a group packet is replaced by the
+// packets it contains, in the order they're sent.
+func StreamPacketIds(packetIds []constants.SensorCode) []constants.SensorCode {
+	var ids []constants.SensorCode
+	for _, packetId := range packetIds {
+		if groupIds, isGroup := constants.SENSOR_GROUP_PACKETS[packetId]; isGroup {
+			ids = append(ids, groupIds...)
+			continue
+		}
+		ids = append(ids, packetId)
+	}
+	return ids
+}
+
+// BuildStreamFrame assembles a stream frame as the OI sends it: the 19 header,
+// N-bytes, the ids and values of the packets in order and the checksum. The
+// values are taken from packets; a packet missing from it has no value. It's
+// the reverse of ParseStreamFrame, for tools that craft frames.
+func BuildStreamFrame(packets map[constants.SensorCode][]byte, order []constants.SensorCode) []byte {
+	frame := []byte{19, 0}
+	for _, packetId := range order {
+		frame = append(frame, byte(packetId))
+		frame = append(frame, packets[packetId]...)
+	}
+	frame[1] = byte(len(frame) - 2)
+	// The checksum makes all the bytes of the frame, from the header, add up
+	// to 0.
+	var sum byte
+	for _, b := range frame {
+		sum += b
+	}
+	return append(frame, -sum)
+}
+
+// ReadStream reads stream frames of the given packets from the port and
+// sends them to out until the stream is paused or stopped. It's normally
+// started by Stream. The values of a group packet are sent as the values of
+// the packets it contains, see StreamPacketIds. It closes out and returns nil
+// once the stream is paused or stopped, or the error ending it, e.g. a frame
+// failing its checksum, or io.EOF when the port is closed.
+func (roomba *Roomba) ReadStream(packetIds []constants.SensorCode, out chan<- [][]byte) error {
+	return roomba.readStream(packetIds, out, roomba.newStream())
+}
+
+// StreamErr returns the error that ended the last stream, or nil if it was
+// paused or stopped, or is still running. Streams started by Stream end on
+// the first frame that can't be read or parsed, closing their channel; this
+// tells why.
+func (roomba *Roomba) StreamErr() error {
+	roomba.mu.Lock()
+	defer roomba.mu.Unlock()
+	return roomba.streamErr
+}
+
+// newStream registers a new active stream, replacing the previous one.
+func (roomba *Roomba) newStream() *activeStream {
+	s := &activeStream{
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		frames: make(chan struct{}, 1),
+	}
+	roomba.mu.Lock()
+	roomba.stream = s
+	roomba.streamErr = nil
+	roomba.mu.Unlock()
+	return s
+}
+
+// StopStream makes the goroutine reading the current stream pause the stream,
+// close its output channel and exit, even if nobody is receiving from the
+// channel. Frames not yet received are discarded.
+func (roomba *Roomba) StopStream() {
+	roomba.mu.Lock()
+	s := roomba.stream
+	roomba.mu.Unlock()
+	if s != nil {
+		s.signalStop()
+	}
+}
+
+// pauseStreamForRead pauses the active stream, if any, between two frames so
+// that the reply to a one-off request can be read from the port. The returned
+// function resumes the stream.
+func (roomba *Roomba) pauseStreamForRead(ctx context.Context) (resume func(), err error) {
+	roomba.mu.Lock()
+	s := roomba.stream
+	roomba.mu.Unlock()
+	if s == nil {
+		return func() {}, nil
+	}
+	select {
+	case s.frames <- struct{}{}:
+	case <-s.done:
+		return func() {}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := roomba.Write(constants.PauseResumeStream, []byte{0}); err != nil {
+		<-s.frames
+		return nil, err
+	}
+	roomba.drain(streamDrainPeriod)
+	return func() {
+		roomba.Write(constants.PauseResumeStream, []byte{1})
+		<-s.frames
+	}, nil
+}
+
+// runStream reads a stream with readStream, logging the error ending it. The
+// error is kept for StreamErr.
+func (roomba *Roomba) runStream(packetIds []constants.SensorCode, out chan<- [][]byte, s *activeStream) {
+	if err := roomba.readStream(packetIds, out, s); err != nil {
+		log.Printf("stream stopped: %v", err)
+	}
+}
+
+// readStream reads stream frames into out until the stream is paused or
+// stopped, when it returns nil, or until a frame can't be read or parsed, when
+// it returns the error. It records the error for StreamErr and closes out
+// when it returns.
+func (roomba *Roomba) readStream(packetIds []constants.SensorCode, out chan<- [][]byte, s *activeStream) (err error) {
+	// Frames are read holding s.frames, so that one-off reads pause the stream
+	// between frames. It's kept on exit, so the stream can't be resumed.
+	held := false
+	lockFrames := func() {
+		s.frames <- struct{}{}
+		held = true
+	}
+	unlockFrames := func() {
+		held = false
+		<-s.frames
+	}
+	defer func() {
+		if !held {
+			lockFrames()
+		}
+		roomba.mu.Lock()
+		roomba.streamErr = err
+		if roomba.stream == s {
+			roomba.stream = nil
+		}
+		roomba.mu.Unlock()
+		close(out)
+		close(s.done)
+	}()
+
+	dataLength, err := PacketsTotalLength(packetIds)
+	if err != nil {
+		return err
 	}
 
 	// Input buffer. 3 is for 19, N-bytes and checksum.
-	buf := make([]byte, dataLength+byte(len(packetIds))+3)
+	buf := make([]byte, dataLength+len(packetIds)+3)
 
 	for {
 	Loop:
 		select {
 		case <-roomba.StreamPaused:
 			// Pause stream.
+			lockFrames()
 			roomba.Write(constants.PauseResumeStream, []byte{0})
-			close(out)
-			return
+			return nil
+		case <-s.stop:
+			lockFrames()
+			roomba.Write(constants.PauseResumeStream, []byte{0})
+			return nil
 		default:
 			// Read single stream frame.
+			lockFrames()
 			bytesRead := 0
 			for bytesRead < len(buf) {
-				n, err := roomba.S.Read(buf[bytesRead:])
+				n, err := roomba.Read(buf[bytesRead:])
 				if n != 0 {
 					bytesRead += n
 				}
 				if err != nil {
 					if err == io.EOF {
-						return
+						return err
 					}
+					unlockFrames()
 					goto Loop
 				}
 			}
-			// Process frame.
-			bufR := bytes.NewReader(buf)
-			if b, err := bufR.ReadByte(); err != nil || b != 19 {
-				log.Fatalf("stream data doesn't start with header 19")
-				return
-			}
-			if b, err := bufR.ReadByte(); err != nil || b != byte(len(buf)-3) {
-				log.Fatalf("invalid N-bytes: %d, expected %d.", buf[1],
-					len(buf)-3)
+			unlockFrames()
+			// Process frame. The length is checked before slicing the
+			// packets, so that a wrong packet length table isn't reported
+			// as a parse error.
+			if buf[0] == 19 && int(buf[1]) != len(buf)-3 {
+				return fmt.Errorf("%w: N-bytes is %d, expected %d for packets %v",
+					ErrFrameLength, buf[1], len(buf)-3, packetIds)
 			}
-
-			result := make([][]byte, len(packetIds))
-
-			i := 0
-			// Used for verifying checksum.
-			sum := byte(len(buf) - 3) // N-bytes
-			packetId, err := bufR.ReadByte()
-			for ; err == nil; packetId, err = bufR.ReadByte() {
-				sum += packetId
-				bytesToRead := int(constants.SENSOR_PACKET_LENGTH[constants.SensorCode(packetId)])
-				bytesRead := 0
-				result[i] = make([]byte, bytesToRead)
-
-				for bytesToRead > 0 {
-					n, err := bufR.Read(result[i][bytesRead:])
-					bytesRead += n
-					bytesToRead -= n
-					if err != nil {
-						log.Fatalf("error reading packet data")
-					}
-				}
-				for _, b := range result[i] {
-					sum += b
-				}
-				i += 1
-				if bufR.Len() == 1 {
-					break
-				}
-			}
-
-			expectedChecksum, err := bufR.ReadByte()
+			_, result, err := parseFrame(buf)
 			if err != nil {
-				log.Fatalf("missing checksum")
+				// The OI may go to sleep while streaming, without
+				// commands.
+				return fmt.Errorf("failed parsing stream frame: %w",
+					checkAsleep(roomba.idleTime(), err))
 			}
-			sum += expectedChecksum
-			if sum != 0 {
-				log.Fatalf("computed checksum didn't match: %d", sum)
+			select {
+			case out <- result:
+			case <-s.stop:
+				lockFrames()
+				roomba.Write(constants.PauseResumeStream, []byte{0})
+				return nil
 			}
-			out <- result
 		}
 	}
 }
@@ -323,17 +992,30 @@ func (roomba *Roomba) ReadStream(packetIds []constants.SensorCode, out chan<- []
 // over a wireless network (which has poor real-time characteristics) with
 // software running on a desktop computer.
 func (roomba *Roomba) Stream(packetIds []constants.SensorCode) (<-chan [][]byte, error) {
-	b := new(bytes.Buffer)
-	b.WriteByte(byte(len(packetIds)))
-	for _, pid := range packetIds {
-		b.WriteByte(byte(pid))
-	}
-	err := roomba.Write(constants.SensorStream, b.Bytes())
+	return roomba.StreamRequest(&StreamRequest{packetIds: packetIds})
+}
+
+// TimestampedFrame is a stream frame with the time it was read.
+type TimestampedFrame struct {
+	Frame      [][]byte
+	ReceivedAt time.Time
+}
+
+// StreamTimestamped starts a stream like Stream, but delivers each frame with
+// the time it was read from the port. Frames are read one at a time, so if
+// they aren't received from the channel in time, they're read, and
+// timestamped, late.
+func (roomba *Roomba) StreamTimestamped(packetIds []constants.SensorCode) (<-chan TimestampedFrame, error) {
+	frames, err := roomba.Stream(packetIds)
 	if err != nil {
 		return nil, err
 	}
-
-	out := make(chan [][]byte)
-	go roomba.ReadStream(packetIds, out)
+	out := make(chan TimestampedFrame)
+	go func() {
+		defer close(out)
+		for frame := range frames {
+			out <- TimestampedFrame{frame, time.Now()}
+		}
+	}()
 	return out, nil
 }
@@ -2,8 +2,14 @@
 package roomba_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"testing"
+	"time"
 
+	"github.com/infinities-within/go-roomba"
 	"github.com/infinities-within/go-roomba/constants"
 	rt "github.com/infinities-within/go-roomba/testing"
 )
@@ -16,6 +22,127 @@ func TestDrive(t *testing.T) {
 	rt.VerifyWritten(r, expected, t)
 }
 
+func TestClampDriveInputs(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	if err := r.Drive(600, -2500); err == nil {
+		t.Errorf("Drive accepted velocity 600 without ClampDriveInputs")
+	}
+	if err := r.DirectDrive(501, 0); err == nil {
+		t.Errorf("DirectDrive accepted velocity 501 without ClampDriveInputs")
+	}
+
+	r.ClampDriveInputs = true
+	for _, err := range []error{
+		r.Drive(600, -2500),
+		r.Drive(-700, roomba.RadiusStraight),
+		r.DirectDrive(501, -1000),
+	} {
+		if err != nil {
+			t.Errorf("clamped drive command failed: %s", err)
+		}
+	}
+	rt.VerifyWritten(r, []byte{
+		137, 1, 244, 248, 48,
+		137, 254, 12, 127, 255,
+		145, 1, 244, 254, 12,
+	}, t)
+}
+
+func TestDrivePWM(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	if err := r.DrivePWM(255, -100); err != nil {
+		t.Fatalf("DrivePWM failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{146, 0, 255, 255, 156}, t)
+	if err := r.DrivePWM(256, 0); err == nil {
+		t.Errorf("DrivePWM accepted 256")
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	commands := map[string]func() error{
+		"Drive":       func() error { return r.Drive(100, 0) },
+		"DirectDrive": func() error { return r.DirectDrive(100, 100) },
+		"DrivePWM":    func() error { return r.DrivePWM(100, 100) },
+		"Motors":      func() error { return r.Motors(1) },
+	}
+
+	r.Start()
+	for name, command := range commands {
+		if err := command(); err != nil {
+			t.Errorf("%s in Passive mode without StrictMode failed: %s", name, err)
+		}
+	}
+	r.StrictMode = true
+	for name, command := range commands {
+		if err := command(); !errors.Is(err, roomba.ErrInvalidMode) {
+			t.Errorf("%s in Passive mode returned %v, expected ErrInvalidMode", name, err)
+		}
+	}
+	r.Safe()
+	for name, command := range commands {
+		if err := command(); err != nil {
+			t.Errorf("%s in Safe mode failed: %s", name, err)
+		}
+	}
+	r.StopOI()
+	if err := r.Drive(100, 0); !errors.Is(err, roomba.ErrInvalidMode) {
+		t.Errorf("Drive in Off mode returned %v, expected ErrInvalidMode", err)
+	}
+}
+
+func TestDriveSpecialRadii(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	for _, radius := range []int16{roomba.RadiusStraight, -32768, roomba.RadiusTurnCW, roomba.RadiusTurnCCW} {
+		if err := r.Drive(200, radius); err != nil {
+			t.Errorf("Drive(200, %d) failed: %s", radius, err)
+		}
+	}
+	rt.VerifyWritten(r, []byte{
+		137, 0, 200, 127, 255,
+		137, 0, 200, 128, 0,
+		137, 0, 200, 255, 255,
+		137, 0, 200, 0, 1,
+	}, t)
+	if err := r.Drive(200, 2001); err == nil {
+		t.Errorf("Drive accepted radius 2001")
+	}
+}
+
+func TestEncodeDrive(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	b, err := roomba.EncodeDrive(-200, 500)
+	if err != nil {
+		t.Fatalf("EncodeDrive failed: %s", err)
+	}
+	r.Drive(-200, 500)
+	rt.VerifyWritten(r, b, t)
+
+	b, err = roomba.EncodeDirectDrive(300, -300)
+	if err != nil {
+		t.Fatalf("EncodeDirectDrive failed: %s", err)
+	}
+	r.DirectDrive(300, -300)
+	rt.VerifyWritten(r, b, t)
+
+	if _, err := roomba.EncodeDrive(501, 0); err == nil {
+		t.Errorf("EncodeDrive accepted velocity 501")
+	}
+	if _, err := roomba.EncodeDrive(0, -2001); err == nil {
+		t.Errorf("EncodeDrive accepted radius -2001")
+	}
+	if _, err := roomba.EncodeDirectDrive(0, -501); err == nil {
+		t.Errorf("EncodeDirectDrive accepted velocity -501")
+	}
+}
+
 func TestLEDs(t *testing.T) {
 	expected := []byte{139, 2, 0, 128}
 	r := rt.MakeTestRoomba()
@@ -71,6 +198,90 @@ func TestStream(t *testing.T) {
 	}
 }
 
+func TestStreamGroup(t *testing.T) {
+	// Left bump, front left cliff, virtual wall and the two unused bytes.
+	payload := []byte{0x02, 0, 0, 1, 0, 0, 1, 0, 0, 0}
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_GROUP_1, payload)
+
+	packetIds := []constants.SensorCode{constants.SENSOR_GROUP_1, constants.SENSOR_VOLTAGE}
+	out, err := r.Stream(packetIds)
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	defer r.StopStream()
+	frame := <-out
+	ids := roomba.StreamPacketIds(packetIds)
+	if len(ids) != 11 || len(frame) != len(ids) {
+		t.Fatalf("got %d values for %d ids %v, expected 11", len(frame), len(ids), ids)
+	}
+	for i, id := range ids[:10] {
+		if id != constants.SensorCode(7+i) || !bytes.Equal(frame[i], payload[i:i+1]) {
+			t.Errorf("value %d is sensor %v % d, expected sensor %d % d", i, id, frame[i], 7+i, payload[i:i+1])
+		}
+	}
+	if ids[10] != constants.SENSOR_VOLTAGE || len(frame[10]) != 2 {
+		t.Errorf("last value is sensor %v % d, expected the voltage", ids[10], frame[10])
+	}
+}
+
+func TestStreamFrameLengthMismatch(t *testing.T) {
+	// The wall packet has 1 byte, but the frame says N-bytes is 3.
+	frame := []byte{19, 3, 8, 1, 0}
+	r, f := rt.MakeFakeRoomba()
+	f.Respond([]byte{148, 1, 8}, frame)
+	packetIds := []constants.SensorCode{constants.SENSOR_WALL}
+
+	if err := r.Write(constants.SensorStream, []byte{1, 8}); err != nil {
+		t.Fatalf("error requesting stream: %s", err)
+	}
+	err := r.ReadStream(packetIds, make(chan [][]byte))
+	if !errors.Is(err, roomba.ErrFrameLength) {
+		t.Fatalf("got error %v, expected ErrFrameLength", err)
+	}
+
+	// Streams are stopped without exiting.
+	out, err := r.Stream(packetIds)
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	if frame, ok := <-out; ok {
+		t.Errorf("got frame %v, expected the stream to stop", frame)
+	}
+	if err := r.StreamErr(); !errors.Is(err, roomba.ErrFrameLength) {
+		t.Errorf("stream ended with %v, expected ErrFrameLength", err)
+	}
+}
+
+func TestStreamChecksumMismatch(t *testing.T) {
+	r, f := rt.MakeFakeRoomba()
+	f.Respond([]byte{148, 1, 8}, []byte{19, 2, 8, 1, 0})
+
+	out, err := r.Stream([]constants.SensorCode{constants.SENSOR_WALL})
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	if frame, ok := <-out; ok {
+		t.Errorf("got frame %v, expected the stream to stop", frame)
+	}
+	if err := r.StreamErr(); !errors.Is(err, roomba.ErrChecksumMismatch) {
+		t.Errorf("stream ended with %v, expected ErrChecksumMismatch", err)
+	}
+
+	// A new stream clears the error.
+	f.Respond([]byte{148, 1, 7}, []byte{19, 2, 7, 0, 228})
+	out, err = r.Stream([]constants.SensorCode{constants.SENSOR_BUMP_WHEELS_DROPS})
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	<-out
+	if err := r.StreamErr(); err != nil {
+		t.Errorf("running stream has error %v", err)
+	}
+	r.StopStream()
+}
+
 func TestPauseStream(t *testing.T) {
 	r := rt.MakeTestRoomba()
 	defer rt.ClearTestRoomba()
@@ -83,3 +294,694 @@ func TestPauseStream(t *testing.T) {
 	expected_input := []byte{148, 0, 150, 0}
 	rt.VerifyWritten(r, expected_input, t)
 }
+
+func TestCapabilityGating(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	r.Model = roomba.ModelRoomba500
+	if err := r.DigitalOutputs(7); err == nil {
+		t.Errorf("DigitalOutputs didn't fail on a 500-series Roomba")
+	}
+	if err := r.SendIR(42); err == nil {
+		t.Errorf("SendIR didn't fail on a 500-series Roomba")
+	}
+
+	r.Model = roomba.ModelCreate2
+	if err := r.DigitalOutputs(7); err == nil {
+		t.Errorf("DigitalOutputs didn't fail on Create 2")
+	}
+
+	r.ForceUnsupported = true
+	if err := r.SendIR(42); err != nil {
+		t.Errorf("forced SendIR failed: %s", err)
+	}
+	r.ForceUnsupported = false
+
+	r.Model = roomba.ModelCreate
+	if err := r.DigitalOutputs(7); err != nil {
+		t.Errorf("DigitalOutputs failed on Create: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{151, 42, 147, 7}, t)
+}
+
+func TestReset(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.ResetAndWait(ctx); err != nil {
+		t.Fatalf("ResetAndWait failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{7, 142, 35}, t)
+}
+
+func TestResetAndWaitBooting(t *testing.T) {
+	// The robot ignores the first two queries while it boots.
+	port := newLatePort(10 * time.Millisecond)
+	port.ignore = 2
+	r := &roomba.Roomba{S: port}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := r.ResetAndWait(ctx); err != nil {
+		t.Fatalf("ResetAndWait failed: %s", err)
+	}
+	// No query of ResetAndWait is left reading the port.
+	value, err := r.Sensors(constants.SENSOR_SONG_NUMBER)
+	if err != nil || value[0] != byte(constants.SENSOR_SONG_NUMBER) {
+		t.Errorf("got reply %v, %v to the next query", value, err)
+	}
+}
+
+// resetOnlyPort accepts the Reset command and fails all other writes.
+type resetOnlyPort struct {
+	brokenPort
+}
+
+func (p *resetOnlyPort) Write(b []byte) (int, error) {
+	if len(b) == 0 || (len(b) == 1 && b[0] == byte(constants.Reset)) {
+		return len(b), nil
+	}
+	return p.brokenPort.Write(b)
+}
+
+func TestResetAndWaitWriteFails(t *testing.T) {
+	port := &resetOnlyPort{}
+	r := &roomba.Roomba{S: port}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1200*time.Millisecond)
+	defer cancel()
+	if err := r.ResetAndWait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	// A query every resetPollInterval (500 ms).
+	if port.writes > 3 {
+		t.Errorf("%d failed queries, expected at most 3", port.writes)
+	}
+}
+
+func TestWaitForMode(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorSequence(constants.SENSOR_OI_MODE, [][]byte{{1}, {1}, {2}})
+	ticks := make(chan time.Time)
+	defer roomba.SetTicker(ticks)()
+	// The mode is read right away and after each of the two ticks.
+	go func() {
+		ticks <- time.Now()
+		ticks <- time.Now()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.WaitForMode(ctx, roomba.OIModeSafe, time.Hour); err != nil {
+		t.Fatalf("WaitForMode failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{142, 35, 142, 35, 142, 35}, t)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := r.WaitForMode(ctx, roomba.OIModeFull, time.Hour); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestStopStream(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	out, err := r.Stream([]constants.SensorCode{constants.SENSOR_VIRTUAL_WALL})
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	// Leave the frame unread so the reader blocks sending it.
+	time.Sleep(100 * time.Millisecond)
+	r.StopStream()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-out:
+			if !ok {
+				rt.VerifyWritten(r, []byte{148, 1, 13, 150, 0}, t)
+				return
+			}
+		case <-timeout:
+			t.Fatalf("stream reader didn't exit after StopStream")
+		}
+	}
+}
+
+// silentPort accepts all writes and blocks reads until closed, like a robot
+// that doesn't answer.
+type silentPort struct {
+	closed chan struct{}
+}
+
+func newSilentPort() *silentPort {
+	return &silentPort{closed: make(chan struct{})}
+}
+
+func (p *silentPort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *silentPort) Read(b []byte) (int, error) {
+	<-p.closed
+	return 0, io.EOF
+}
+
+func (p *silentPort) Close() error {
+	close(p.closed)
+	return nil
+}
+
+// brokenPort fails all writes and counts them.
+type brokenPort struct {
+	writes int
+}
+
+func (p *brokenPort) Write(b []byte) (int, error) {
+	p.writes++
+	return 0, errors.New("port broken")
+}
+
+func (p *brokenPort) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func TestControl(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	if err := r.Control(); err != nil {
+		t.Fatalf("Control failed: %s", err)
+	}
+	rt.VerifySensorValue(r, constants.SENSOR_OI_MODE, []byte{2}, t)
+	rt.VerifyWritten(r, []byte{128, 130, 142, 35}, t)
+
+	port := &brokenPort{}
+	broken := &roomba.Roomba{S: port, StreamPaused: make(chan bool, 1)}
+	if err := broken.Control(); err == nil {
+		t.Errorf("Control succeeded on a broken port")
+	}
+	if port.writes != 1 {
+		t.Errorf("got %d writes, expected Control to stop after Start failed", port.writes)
+	}
+}
+
+func TestMakeRoombaRetry(t *testing.T) {
+	port := newSilentPort()
+	defer port.Close()
+	attempts := 0
+	defer roomba.SetOpenPort(func(name string, baud int) (io.ReadWriteCloser, error) {
+		attempts++
+		if name != "/dev/ttyUSB0" || baud != 115200 {
+			t.Errorf("opened %s at %d, expected /dev/ttyUSB0 at 115200", name, baud)
+		}
+		if attempts == 1 {
+			return nil, errors.New("no such file or directory")
+		}
+		return port, nil
+	})()
+
+	r, err := roomba.MakeRoombaRetry("/dev/ttyUSB0", 115200, 3, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("MakeRoombaRetry failed: %s", err)
+	}
+	if attempts != 2 {
+		t.Errorf("port opened in %d attempts, expected 2", attempts)
+	}
+	if r.S != port {
+		t.Errorf("Roomba doesn't use the opened port")
+	}
+}
+
+func TestMakeRoombaRetryExhausted(t *testing.T) {
+	attempts := 0
+	openErr := errors.New("no such file or directory")
+	defer roomba.SetOpenPort(func(name string, baud int) (io.ReadWriteCloser, error) {
+		attempts++
+		return nil, openErr
+	})()
+
+	if _, err := roomba.MakeRoombaRetry("/dev/ttyUSB0", 57600, 3, time.Millisecond); !errors.Is(err, openErr) {
+		t.Errorf("expected the open error, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("port opened in %d attempts, expected 3", attempts)
+	}
+}
+
+func TestSensorsContext(t *testing.T) {
+	port := newSilentPort()
+	defer port.Close()
+	r := &roomba.Roomba{S: port}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err := r.SensorsContext(ctx, constants.SENSOR_OI_MODE)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("SensorsContext returned %v after cancellation", elapsed)
+	}
+
+	sr := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	value, err := sr.SensorsContext(context.Background(), constants.SENSOR_OI_MODE)
+	if err != nil || len(value) != 1 || value[0] != 2 {
+		t.Errorf("unexpected OI mode read: %v, %v", value, err)
+	}
+}
+
+// latePort answers Sensors requests for 1-byte packets with the packet id
+// after delay, like a slow robot.
+type latePort struct {
+	delay   time.Duration
+	ignore  int  // The number of requests ignored before answering.
+	sensors bool // Whether the last write was the Sensors opcode.
+	replies chan byte
+}
+
+func newLatePort(delay time.Duration) *latePort {
+	return &latePort{delay: delay, replies: make(chan byte, 16)}
+}
+
+func (p *latePort) Write(b []byte) (int, error) {
+	if p.sensors && len(b) == 1 {
+		if p.ignore > 0 {
+			p.ignore--
+		} else {
+			id := b[0]
+			time.AfterFunc(p.delay, func() { p.replies <- id })
+		}
+	}
+	p.sensors = len(b) == 1 && b[0] == byte(constants.Sensors)
+	return len(b), nil
+}
+
+func (p *latePort) Read(b []byte) (int, error) {
+	b[0] = <-p.replies
+	return 1, nil
+}
+
+func TestSensorsContextLateReply(t *testing.T) {
+	r := &roomba.Roomba{S: newLatePort(50 * time.Millisecond)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := r.SensorsContext(ctx, constants.SENSOR_OI_MODE); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	// The late reply to the cancelled query isn't read as this one's.
+	value, err := r.Sensors(constants.SENSOR_SONG_NUMBER)
+	if err != nil {
+		t.Fatalf("error reading song number: %s", err)
+	}
+	if value[0] != byte(constants.SENSOR_SONG_NUMBER) {
+		t.Errorf("read %d, the reply to packet %d", value[0], value[0])
+	}
+}
+
+func TestSongState(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	number, playing, err := r.SongState()
+	if err != nil {
+		t.Fatalf("error reading song state: %s", err)
+	}
+	if number != 1 || playing {
+		t.Errorf("expected song 1 not playing, got song %d playing %v", number, playing)
+	}
+
+	r.Play(3)
+	number, playing, err = r.SongState()
+	if err != nil {
+		t.Fatalf("error reading song state: %s", err)
+	}
+	if number != 3 || !playing {
+		t.Errorf("expected song 3 playing, got song %d playing %v", number, playing)
+	}
+	rt.VerifyWritten(r, []byte{149, 2, 36, 37, 141, 3, 149, 2, 36, 37}, t)
+}
+
+func TestEmergencyStop(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	if err := r.EmergencyStop(); err != nil {
+		t.Fatalf("EmergencyStop failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{137, 0, 0, 0, 0, 138, 0, 128}, t)
+}
+
+func TestDriveAfterEmergencyStop(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.EmergencyStop()
+	rt.VerifyWritten(r, []byte{137, 0, 0, 0, 0, 138, 0, 128}, t)
+
+	if err := r.Drive(100, 0); !errors.Is(err, roomba.ErrStopped) {
+		t.Errorf("Drive after EmergencyStop returned %v, expected ErrStopped", err)
+	}
+	if err := r.DirectDrive(100, 100); !errors.Is(err, roomba.ErrStopped) {
+		t.Errorf("DirectDrive after EmergencyStop returned %v, expected ErrStopped", err)
+	}
+	if err := r.Stop(); err != nil {
+		t.Errorf("Stop after EmergencyStop failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{137, 0, 0, 0, 0}, t)
+
+	if err := r.Safe(); err != nil {
+		t.Fatalf("Safe failed: %s", err)
+	}
+	if err := r.Drive(100, 0); err != nil {
+		t.Errorf("Drive after Safe failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{131, 137, 0, 100, 0, 0}, t)
+}
+
+func TestPing(t *testing.T) {
+	port := newSilentPort()
+	defer port.Close()
+	if err := (&roomba.Roomba{S: port}).Ping(); err == nil {
+		t.Errorf("Ping of an unresponsive robot succeeded")
+	}
+
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	if err := r.Ping(); err != nil {
+		t.Errorf("Ping failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{142, 35}, t)
+}
+
+func TestAutoStart(t *testing.T) {
+	for _, autoStart := range []bool{false, true} {
+		r := rt.MakeTestRoomba()
+		rt.SetSensorValue(constants.SENSOR_OI_MODE, []byte{0})
+		r.AutoStart = autoStart
+		r.AutoStartMode = roomba.OIModeSafe
+
+		if err := r.Drive(100, 200); err != nil {
+			t.Errorf("Drive failed: %s", err)
+		}
+		expected := []byte{0, 0}
+		if autoStart {
+			expected = []byte{0, 100}
+		}
+		rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, expected, t)
+		if autoStart {
+			rt.VerifyWritten(r, []byte{142, 35, 128, 131, 137, 0, 100, 0, 200}, t)
+			rt.VerifySensorValue(r, constants.SENSOR_OI_MODE, []byte{2}, t)
+		}
+		rt.ClearTestRoomba()
+	}
+}
+
+func TestAutoStartFromPassive(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_OI_MODE, []byte{1})
+	r.AutoStart = true
+	r.AutoStartMode = roomba.OIModeSafe
+
+	if err := r.Drive(100, 200); err != nil {
+		t.Errorf("Drive failed: %s", err)
+	}
+	// Start isn't needed, but Safe is.
+	rt.VerifyWritten(r, []byte{142, 35, 131, 137, 0, 100, 0, 200}, t)
+	rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 100}, t)
+
+	rt.ClearTestRoomba()
+
+	// Nothing is sent if the OI is already in AutoStartMode.
+	already := rt.MakeTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_OI_MODE, []byte{2})
+	already.AutoStart = true
+	already.AutoStartMode = roomba.OIModeSafe
+	if err := already.Motors(1); err != nil {
+		t.Errorf("Motors failed: %s", err)
+	}
+	rt.VerifyWritten(already, []byte{142, 35, 138, 1}, t)
+}
+
+func TestDemo(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.Safe()
+	if err := r.Demo(10); err == nil {
+		t.Errorf("Demo accepted demo 10")
+	}
+	if err := r.Demo(3); err != nil {
+		t.Fatalf("Demo failed: %s", err)
+	}
+	rt.VerifySensorValue(r, constants.SENSOR_OI_MODE, []byte{1}, t)
+	if err := r.StopDemo(); err != nil {
+		t.Fatalf("StopDemo failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{131, 136, 3, 142, 35, 136, 255}, t)
+}
+
+func TestDemoUnsupported(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	r.Model = roomba.ModelRoomba500
+	if err := r.Demo(3); err == nil {
+		t.Errorf("Demo didn't fail on a 500-series Roomba")
+	}
+	if err := r.StopDemo(); err == nil {
+		t.Errorf("StopDemo didn't fail on a 500-series Roomba")
+	}
+	rt.VerifyWritten(r, []byte{}, t)
+}
+
+func TestStartBeforeCleaning(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	r.Clean()
+	rt.VerifyWritten(r, []byte{135}, t)
+
+	r.StartBeforeCleaning = true
+	r.Clean()
+	r.Spot()
+	r.SeekDock()
+	rt.VerifyWritten(r, []byte{128, 135, 134, 143}, t)
+}
+
+func TestStartBeforeCleaningStarted(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.StartBeforeCleaning = true
+
+	r.Start()
+	r.SeekDock()
+	rt.VerifyWritten(r, []byte{128, 143}, t)
+}
+
+func TestSensorsDuringStream(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	out, err := r.Stream([]constants.SensorCode{constants.SENSOR_VIRTUAL_WALL})
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	if frame := <-out; frame[0][0] != 5 {
+		t.Errorf("stream frame before read: %v", frame)
+	}
+
+	values := make(chan []byte)
+	go func() {
+		value, err := r.Sensors(constants.SENSOR_TEMPERATURE)
+		if err != nil {
+			t.Errorf("Sensors failed during stream: %s", err)
+		}
+		values <- value
+	}()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case frame := <-out:
+			if frame[0][0] != 5 {
+				t.Errorf("stream frame during read: %v", frame)
+			}
+			continue
+		case value := <-values:
+			if len(value) != 1 || value[0] != 25 {
+				t.Errorf("temperature is %v, expected 25", value)
+			}
+		case <-timeout:
+			t.Fatalf("Sensors didn't return during stream")
+		}
+		break
+	}
+
+	// The stream is resumed after the read.
+	select {
+	case frame := <-out:
+		if frame[0][0] != 5 {
+			t.Errorf("stream frame after read: %v", frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("stream wasn't resumed after Sensors")
+	}
+	r.StopStream()
+	for range out {
+	}
+	rt.VerifyWritten(r, []byte{148, 1, 13, 150, 0, 142, 24, 150, 1, 150, 0}, t)
+}
+
+func TestQueryMap(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	values, err := r.QueryMap([]constants.SensorCode{
+		constants.SENSOR_BATTERY_CHARGE,
+		constants.SENSOR_TEMPERATURE,
+		constants.SENSOR_VIRTUAL_WALL})
+	if err != nil {
+		t.Fatalf("QueryMap failed: %s", err)
+	}
+	expected := map[constants.SensorCode][]byte{
+		constants.SENSOR_BATTERY_CHARGE: {3, 232},
+		constants.SENSOR_TEMPERATURE:    {25},
+		constants.SENSOR_VIRTUAL_WALL:   {5},
+	}
+	if len(values) != len(expected) {
+		t.Errorf("got %d values, expected %d: %v", len(values), len(expected), values)
+	}
+	for id, e := range expected {
+		if !bytes.Equal(values[id], e) {
+			t.Errorf("sensor %d is %v, expected %v", id, values[id], e)
+		}
+	}
+	rt.VerifyWritten(r, []byte{149, 3, 25, 24, 13}, t)
+
+	if _, err := r.QueryMap([]constants.SensorCode{constants.SENSOR_WALL, constants.SENSOR_WALL}); err == nil {
+		t.Errorf("QueryMap accepted duplicate ids")
+	}
+}
+
+func TestRampLowSideDriver(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	if err := r.RampLowSideDriver(3, 0, 128, 0); err == nil {
+		t.Errorf("RampLowSideDriver accepted driver 3")
+	}
+	if err := r.RampLowSideDriver(1, 0, 129, 0); err == nil {
+		t.Errorf("RampLowSideDriver accepted duty cycle 129")
+	}
+	if err := r.RampLowSideDriver(1, 0, 128, 80*time.Millisecond); err != nil {
+		t.Fatalf("RampLowSideDriver failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{
+		144, 0, 32, 0,
+		144, 0, 64, 0,
+		144, 0, 96, 0,
+		144, 0, 128, 0,
+	}, t)
+}
+
+func TestParseStreamFrame(t *testing.T) {
+	// Example from the OI spec: packet 29 is 2 25, packet 13 is 0.
+	values, err := roomba.ParseStreamFrame([]byte{19, 5, 29, 2, 25, 13, 0, 163})
+	if err != nil {
+		t.Fatalf("error parsing valid frame: %s", err)
+	}
+	if len(values) != 2 {
+		t.Errorf("got %d packets, expected 2", len(values))
+	}
+	if v := values[constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL]; !bytes.Equal(v, []byte{2, 25}) {
+		t.Errorf("cliff front left signal is %v, expected [2 25]", v)
+	}
+	if v := values[constants.SENSOR_VIRTUAL_WALL]; !bytes.Equal(v, []byte{0}) {
+		t.Errorf("virtual wall is %v, expected [0]", v)
+	}
+
+	bad := singlePacketFrame(constants.SENSOR_VIRTUAL_WALL, 1)
+	bad[len(bad)-1]++
+	if _, err := roomba.ParseStreamFrame(bad); !errors.Is(err, roomba.ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch for bad checksum, got %v", err)
+	}
+	bad = singlePacketFrame(constants.SENSOR_VIRTUAL_WALL, 1)
+	bad[0] = 20
+	if _, err := roomba.ParseStreamFrame(bad); !errors.Is(err, roomba.ErrInvalidFrame) {
+		t.Errorf("expected ErrInvalidFrame for bad header, got %v", err)
+	}
+	bad = singlePacketFrame(constants.SENSOR_VIRTUAL_WALL, 1)
+	bad[1]++
+	if _, err := roomba.ParseStreamFrame(bad); !errors.Is(err, roomba.ErrInvalidFrame) {
+		t.Errorf("expected ErrInvalidFrame for bad N-bytes, got %v", err)
+	}
+}
+
+func TestBuildStreamFrame(t *testing.T) {
+	// Example from the OI spec: packet 29 is 2 25, packet 13 is 0.
+	frame := roomba.BuildStreamFrame(map[constants.SensorCode][]byte{
+		constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL: {2, 25},
+		constants.SENSOR_VIRTUAL_WALL:            {0},
+	}, []constants.SensorCode{constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL, constants.SENSOR_VIRTUAL_WALL})
+	if expected := []byte{19, 5, 29, 2, 25, 13, 0, 163}; !bytes.Equal(frame, expected) {
+		t.Errorf("built frame %v, expected %v", frame, expected)
+	}
+
+	frame = roomba.BuildStreamFrame(map[constants.SensorCode][]byte{
+		constants.SENSOR_CURRENT: {0xfe, 0x0c},
+	}, []constants.SensorCode{constants.SENSOR_CURRENT})
+	if _, err := roomba.ParseStreamFrame(frame); err != nil {
+		t.Errorf("error parsing built frame %v: %s", frame, err)
+	}
+}
+
+func TestStopOI(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	if err := r.StopOI(); err != nil {
+		t.Fatalf("StopOI failed: %s", err)
+	}
+	rt.VerifySensorValue(r, constants.SENSOR_OI_MODE, []byte{0}, t)
+	// The stopped OI ignores drive commands.
+	r.Drive(100, 0)
+	rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 0}, t)
+	rt.VerifyWritten(r, []byte{173, 142, 35, 137, 0, 100, 0, 0, 142, 39}, t)
+}
+
+func TestStreamTimestamped(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	out, err := r.StreamTimestamped([]constants.SensorCode{constants.SENSOR_VIRTUAL_WALL})
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	var frames []roomba.TimestampedFrame
+	for len(frames) < 10 {
+		frames = append(frames, <-out)
+	}
+	r.StopStream()
+	for range out {
+	}
+
+	for i := 1; i < len(frames); i++ {
+		if !frames[i].ReceivedAt.After(frames[i-1].ReceivedAt) {
+			t.Errorf("frame %d wasn't received after frame %d", i, i-1)
+		}
+	}
+	// The simulator sends a frame every 15 ms.
+	mean := frames[len(frames)-1].ReceivedAt.Sub(frames[0].ReceivedAt) / time.Duration(len(frames)-1)
+	if mean < 10*time.Millisecond || mean > 30*time.Millisecond {
+		t.Errorf("frames are %v apart on average, expected about 15ms", mean)
+	}
+}
@@ -0,0 +1,165 @@
+package roomba
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultBaud is the baud rate of the OI after power on.
+const defaultBaud = 57600
+
+// Connect opens a Roomba connection described by uri, which is either a
+// serial port, e.g. serial:///dev/ttyUSB0?baud=115200, or a TCP address of a
+// serial bridge, e.g. tcp://192.168.1.50:23. The baud rate defaults to
+// 57600. As with MakeRoomba, the Roomba is returned even if the port can't be
+// opened.
+func Connect(uri string) (*Roomba, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "serial":
+		baud := uint64(defaultBaud)
+		if b := u.Query().Get("baud"); b != "" {
+			if baud, err = strconv.ParseUint(b, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid baud rate %q: %w", b, err)
+			}
+		}
+		roomba := &Roomba{PortName: u.Path, StreamPaused: make(chan bool, 1)}
+		return roomba, roomba.Open(uint(baud))
+	case "tcp":
+		roomba := &Roomba{StreamPaused: make(chan bool, 1)}
+		conn, err := net.Dial("tcp", u.Host)
+		if err != nil {
+			return roomba, err
+		}
+		roomba.S = conn
+		return roomba, nil
+	}
+	return nil, fmt.Errorf("unsupported transport %q in %q", u.Scheme, uri)
+}
+
+// defaultTCPKeepAlive is the period of the TCP keepalives of
+// MakeRoombaTCPReconnecting.
+const defaultTCPKeepAlive = 15 * time.Second
+
+// ReconnectingTCP is a port on a TCP serial bridge, e.g. ser2net or a WiFi
+// module, that reconnects when the connection drops. Nothing is buffered: the
+// read or write that finds the connection broken returns its error, and the
+// next one dials again. A write that fails is retried once on a new
+// connection, unless part of it was sent, so commands resume as soon as the
+// bridge is back. Writes may succeed for a while after the bridge is gone,
+// until the connection is found broken, so those commands are lost. Streams are
+// interrupted, StreamResilient restarts them.
+type ReconnectingTCP struct {
+	Address     string
+	DialTimeout time.Duration
+	// KeepAlive is the period of TCP keepalives, which detect a bridge that
+	// went away without closing the connection. Zero uses the default of
+	// net.Dialer and a negative value disables them.
+	KeepAlive time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// MakeRoombaTCPReconnecting connects to a TCP serial bridge at address with a
+// ReconnectingTCP port sending keepalives. As with MakeRoomba, the Roomba is
+// returned even if the first connection fails; the port dials again when
+// it's used.
+func MakeRoombaTCPReconnecting(address string, dialTimeout time.Duration) (*Roomba, error) {
+	port := &ReconnectingTCP{Address: address, DialTimeout: dialTimeout, KeepAlive: defaultTCPKeepAlive}
+	roomba := &Roomba{S: port, StreamPaused: make(chan bool, 1)}
+	_, err := port.connect()
+	return roomba, err
+}
+
+// connect returns the current connection, dialing a new one if there's none.
+func (t *ReconnectingTCP) connect() (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, io.ErrClosedPipe
+	}
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	d := net.Dialer{Timeout: t.DialTimeout, KeepAlive: t.KeepAlive}
+	conn, err := d.Dial("tcp", t.Address)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("connected to %s", t.Address)
+	t.conn = conn
+	return conn, nil
+}
+
+// drop closes conn after it failed with err, unless it was replaced already.
+func (t *ReconnectingTCP) drop(conn net.Conn, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == conn {
+		log.Printf("connection to %s dropped: %v", t.Address, err)
+		conn.Close()
+		t.conn = nil
+	}
+}
+
+// Read reads from the current connection, dialing one if needed.
+func (t *ReconnectingTCP) Read(p []byte) (int, error) {
+	conn, err := t.connect()
+	if err == io.ErrClosedPipe {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := conn.Read(p)
+	if err != nil {
+		t.drop(conn, err)
+	}
+	return n, err
+}
+
+// Write writes to the current connection, dialing one if needed. If the
+// write fails, it's retried once on a new connection.
+func (t *ReconnectingTCP) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var conn net.Conn
+		if conn, err = t.connect(); err != nil {
+			return 0, err
+		}
+		if n, err = conn.Write(p); err == nil {
+			return n, nil
+		}
+		t.drop(conn, err)
+		if n > 0 {
+			// Retrying would repeat the part that was sent.
+			break
+		}
+	}
+	return n, err
+}
+
+// Close closes the connection. Later reads return io.EOF and writes fail.
+func (t *ReconnectingTCP) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
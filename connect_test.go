@@ -0,0 +1,112 @@
+package roomba_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+)
+
+func TestConnectTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer l.Close()
+
+	r, err := roomba.Connect("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	conn, ok := r.S.(net.Conn)
+	if !ok {
+		t.Fatalf("port is %T, expected a net.Conn", r.S)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != l.Addr().String() {
+		t.Errorf("connected to %s, expected %s", conn.RemoteAddr(), l.Addr())
+	}
+}
+
+func TestConnectSerial(t *testing.T) {
+	// The device doesn't exist, so opening fails after dispatching.
+	r, err := roomba.Connect("serial:///dev/nonexistent-roomba?baud=115200")
+	if err == nil {
+		t.Errorf("opening a missing serial port succeeded")
+	}
+	if r == nil || r.PortName != "/dev/nonexistent-roomba" {
+		t.Errorf("serial port not selected: %+v", r)
+	}
+
+	for _, uri := range []string{
+		"serial:///dev/ttyUSB0?baud=fast",
+		"serial:///dev/ttyUSB0?baud=12345",
+		"udp://192.168.1.50:23",
+	} {
+		if _, err := roomba.Connect(uri); err == nil {
+			t.Errorf("Connect(%q) succeeded", uri)
+		}
+	}
+}
+
+func TestMakeRoombaTCPReconnecting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	address := l.Addr().String()
+	r, err := roomba.MakeRoombaTCPReconnecting(address, time.Second)
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer r.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %s", err)
+	}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(conn, b); err != nil || b[0] != 128 {
+		t.Fatalf("read %v, %v, expected Start", b, err)
+	}
+
+	// The bridge goes away and comes back.
+	conn.Close()
+	l.Close()
+	l, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("error listening again: %s", err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// Writes to the dropped connection may succeed until it's detected.
+	deadline := time.After(2 * time.Second)
+	for conn = nil; conn == nil; {
+		r.Safe()
+		select {
+		case conn = <-accepted:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("no reconnection")
+		}
+	}
+	defer conn.Close()
+	// The command that found the connection dropped may have been lost.
+	if err := r.Safe(); err != nil {
+		t.Errorf("Safe failed after reconnecting: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, b); err != nil || b[0] != 131 {
+		t.Errorf("read %v, %v after reconnecting, expected Safe", b, err)
+	}
+}
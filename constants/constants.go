@@ -1,6 +1,8 @@
 // Package constants defines values for OpenInterface op codes, sensor codes and sensor packet lengths among others.
 package constants
 
+import "fmt"
+
 type OpCode byte
 
 const (
@@ -9,7 +11,7 @@ const (
     Control
     Safe
     Full
-    _ // 133 is unused
+    Power
     Spot
     Cover
     Demo
@@ -22,7 +24,7 @@ const (
     Dock
     PWMLowSideDrivers
     DriveDirect
-    _ // 146 unused
+    DrivePWM
     DigitalOutputs
     SensorStream
     QueryList
@@ -37,6 +39,63 @@ const (
     WaitEvent
 )
 
+// Reset reboots the robot as if the battery had been removed and reinserted.
+// Only supported by some firmwares.
+const Reset = OpCode(7)
+
+// Stop stops the OI on Create 2 and 600-series Roombas. The robot goes to
+// Off mode and ignores all commands except Start.
+const Stop = OpCode(173)
+
+// Motors is the name of the LowSideDrivers opcode on Roomba, where it controls
+// the cleaning motors.
+const Motors = LowSideDrivers
+
+var opCodeNames = map[OpCode]string{
+    Start:             "Start",
+    Baud:              "Baud",
+    Control:           "Control",
+    Safe:              "Safe",
+    Full:              "Full",
+    Power:             "Power",
+    Spot:              "Spot",
+    Cover:             "Cover",
+    Demo:              "Demo",
+    Drive:             "Drive",
+    LowSideDrivers:    "LowSideDrivers",
+    LEDs:              "LEDs",
+    Song:              "Song",
+    Play:              "Play",
+    Sensors:           "Sensors",
+    Dock:              "Dock",
+    PWMLowSideDrivers: "PWMLowSideDrivers",
+    DriveDirect:       "DriveDirect",
+    DrivePWM:          "DrivePWM",
+    DigitalOutputs:    "DigitalOutputs",
+    SensorStream:      "SensorStream",
+    QueryList:         "QueryList",
+    PauseResumeStream: "PauseResumeStream",
+    SendIR:            "SendIR",
+    Script:            "Script",
+    PlayScript:        "PlayScript",
+    ShowScript:        "ShowScript",
+    WaitTime:          "WaitTime",
+    WaitDistance:      "WaitDistance",
+    WaitAngle:         "WaitAngle",
+    WaitEvent:         "WaitEvent",
+    Reset:             "Reset",
+    Stop:              "Stop",
+}
+
+// String returns the name of the opcode's constant, e.g. "Drive", or
+// "OpCode(n)" for unknown opcodes.
+func (o OpCode) String() string {
+    if name, ok := opCodeNames[o]; ok {
+        return name
+    }
+    return fmt.Sprintf("OpCode(%d)", byte(o))
+}
+
 type SensorCode byte
 
 // SENSOR_* constants define the packet IDs for declared sensor packets.
@@ -96,13 +155,15 @@ const (
     // divided by two. Positive values indicate travel in the forward direction;
     // negative values indicate travel in the reverse direction. If the value is
     // not polled frequently enough, it is capped at its minimum or maximum.
-    // Range: -32768 – 32767
+    // Reading the value resets it, so the first read after a pause includes
+    // all travel since the previous read. Range: -32768 – 32767
     SENSOR_DISTANCE
 
     // The angle in degrees that Roomba has turned since the angle was last
     // requested. Counter-clockwise angles are positive and clockwise angles
     // are negative. If the value is not polled frequently enough, it is capped
-    // at its minimum or maximum. Range: -32768 – 32767
+    // at its minimum or maximum. Like distance, reading the value resets it.
+    // Range: -32768 – 32767
     SENSOR_ANGLE
 
     // This code indicates Roomba’s current charging state. Range: 0 – 5
@@ -188,6 +249,185 @@ const (
     SENSOR_LEFT_VELOCITY
 )
 
+// Wheel encoder and stasis sensors of Roomba 600 and Create 2 robots. They
+// aren't consecutive, so each has an explicit packet ID.
+const (
+    // The cumulative number of raw left encoder counts, as a 16-bit value
+    // that rolls over. There are 508.8 counts per wheel revolution.
+    SENSOR_LEFT_ENCODER_COUNTS = SensorCode(43)
+
+    // The cumulative number of raw right encoder counts, as a 16-bit value
+    // that rolls over.
+    SENSOR_RIGHT_ENCODER_COUNTS = SensorCode(44)
+
+    // The stasis caster state: bit 0 is set while the caster is turning,
+    // i.e. while the robot is moving forward, and bit 1 is set if the sensor
+    // is disabled.
+    SENSOR_STASIS = SensorCode(58)
+)
+
+// Light bump sensors of Roomba 600 and Create 2 robots.
+const (
+    // The light bumper detections are sent as individual bits (0 = no light
+    // bump, 1 = light bump): bit 0 is left, 1 front left, 2 center left,
+    // 3 center right, 4 front right and 5 right.
+    SENSOR_LIGHT_BUMPER = SensorCode(iota + 45)
+
+    // The strength of the light bump left signal. Range: 0-4095.
+    SENSOR_LIGHT_BUMP_LEFT_SIGNAL
+
+    // The strength of the light bump front left signal. Range: 0-4095.
+    SENSOR_LIGHT_BUMP_FRONT_LEFT_SIGNAL
+
+    // The strength of the light bump center left signal. Range: 0-4095.
+    SENSOR_LIGHT_BUMP_CENTER_LEFT_SIGNAL
+
+    // The strength of the light bump center right signal. Range: 0-4095.
+    SENSOR_LIGHT_BUMP_CENTER_RIGHT_SIGNAL
+
+    // The strength of the light bump front right signal. Range: 0-4095.
+    SENSOR_LIGHT_BUMP_FRONT_RIGHT_SIGNAL
+
+    // The strength of the light bump right signal. Range: 0-4095.
+    SENSOR_LIGHT_BUMP_RIGHT_SIGNAL
+)
+
+// Motor current sensors of Roomba 600 and Create 2 robots.
+const (
+    // The current in mA drawn by the left wheel motor, as a signed 16-bit
+    // value. Range: -32768 – 32767 mA
+    SENSOR_LEFT_MOTOR_CURRENT = SensorCode(iota + 54)
+
+    // The current in mA drawn by the right wheel motor. Range: -32768 – 32767 mA
+    SENSOR_RIGHT_MOTOR_CURRENT
+
+    // The current in mA drawn by the main brush motor. Range: -32768 – 32767 mA
+    SENSOR_MAIN_BRUSH_MOTOR_CURRENT
+
+    // The current in mA drawn by the side brush motor. Range: -32768 – 32767 mA
+    SENSOR_SIDE_BRUSH_MOTOR_CURRENT
+)
+
+var sensorNames = map[SensorCode]string{
+    SENSOR_BUMP_WHEELS_DROPS:        "SENSOR_BUMP_WHEELS_DROPS",
+    SENSOR_WALL:                     "SENSOR_WALL",
+    SENSOR_CLIFF_LEFT:               "SENSOR_CLIFF_LEFT",
+    SENSOR_CLIFF_FRONT_LEFT:         "SENSOR_CLIFF_FRONT_LEFT",
+    SENSOR_CLIFF_FRONT_RIGHT:        "SENSOR_CLIFF_FRONT_RIGHT",
+    SENSOR_CLIFF_RIGHT:              "SENSOR_CLIFF_RIGHT",
+    SENSOR_VIRTUAL_WALL:             "SENSOR_VIRTUAL_WALL",
+    SENSOR_WHEEL_OVERCURRENT:        "SENSOR_WHEEL_OVERCURRENT",
+    SENSOR_IR_OMNI:                  "SENSOR_IR_OMNI",
+    SENSOR_BUTTONS:                  "SENSOR_BUTTONS",
+    SENSOR_DISTANCE:                 "SENSOR_DISTANCE",
+    SENSOR_ANGLE:                    "SENSOR_ANGLE",
+    SENSOR_CHARGING:                 "SENSOR_CHARGING",
+    SENSOR_VOLTAGE:                  "SENSOR_VOLTAGE",
+    SENSOR_CURRENT:                  "SENSOR_CURRENT",
+    SENSOR_TEMPERATURE:              "SENSOR_TEMPERATURE",
+    SENSOR_BATTERY_CHARGE:           "SENSOR_BATTERY_CHARGE",
+    SENSOR_BATTERY_CAPACITY:         "SENSOR_BATTERY_CAPACITY",
+    SENSOR_WALL_SIGNAL:              "SENSOR_WALL_SIGNAL",
+    SENSOR_CLIFF_LEFT_SIGNAL:        "SENSOR_CLIFF_LEFT_SIGNAL",
+    SENSOR_CLIFF_FRONT_LEFT_SIGNAL:  "SENSOR_CLIFF_FRONT_LEFT_SIGNAL",
+    SENSOR_CLIFF_FRONT_RIGHT_SIGNAL: "SENSOR_CLIFF_FRONT_RIGHT_SIGNAL",
+    SENSOR_CLIFF_RIGHT_SIGNAL:       "SENSOR_CLIFF_RIGHT_SIGNAL",
+    SENSOR_DIGITAL_INPUTS:           "SENSOR_DIGITAL_INPUTS",
+    SENSOR_ANALOG_INPUT:             "SENSOR_ANALOG_INPUT",
+    SENSOR_CHARGING_SOURCE:          "SENSOR_CHARGING_SOURCE",
+    SENSOR_OI_MODE:                  "SENSOR_OI_MODE",
+    SENSOR_SONG_NUMBER:              "SENSOR_SONG_NUMBER",
+    SENSOR_SONG_PLAYING:             "SENSOR_SONG_PLAYING",
+    SENSOR_NUM_STREAM_PACKETS:       "SENSOR_NUM_STREAM_PACKETS",
+    SENSOR_REQUESTED_VELOCITY:       "SENSOR_REQUESTED_VELOCITY",
+    SENSOR_REQUESTED_RADIUS:         "SENSOR_REQUESTED_RADIUS",
+    SENSOR_RIGHT_VELOCITY:           "SENSOR_RIGHT_VELOCITY",
+    SENSOR_LEFT_VELOCITY:            "SENSOR_LEFT_VELOCITY",
+
+    // Encoder and stasis sensors.
+    SENSOR_LEFT_ENCODER_COUNTS:  "SENSOR_LEFT_ENCODER_COUNTS",
+    SENSOR_RIGHT_ENCODER_COUNTS: "SENSOR_RIGHT_ENCODER_COUNTS",
+    SENSOR_STASIS:               "SENSOR_STASIS",
+
+    // Light bump sensors.
+    SENSOR_LIGHT_BUMPER:                   "SENSOR_LIGHT_BUMPER",
+    SENSOR_LIGHT_BUMP_LEFT_SIGNAL:         "SENSOR_LIGHT_BUMP_LEFT_SIGNAL",
+    SENSOR_LIGHT_BUMP_FRONT_LEFT_SIGNAL:   "SENSOR_LIGHT_BUMP_FRONT_LEFT_SIGNAL",
+    SENSOR_LIGHT_BUMP_CENTER_LEFT_SIGNAL:  "SENSOR_LIGHT_BUMP_CENTER_LEFT_SIGNAL",
+    SENSOR_LIGHT_BUMP_CENTER_RIGHT_SIGNAL: "SENSOR_LIGHT_BUMP_CENTER_RIGHT_SIGNAL",
+    SENSOR_LIGHT_BUMP_FRONT_RIGHT_SIGNAL:  "SENSOR_LIGHT_BUMP_FRONT_RIGHT_SIGNAL",
+    SENSOR_LIGHT_BUMP_RIGHT_SIGNAL:        "SENSOR_LIGHT_BUMP_RIGHT_SIGNAL",
+
+    // Motor current sensors.
+    SENSOR_LEFT_MOTOR_CURRENT:       "SENSOR_LEFT_MOTOR_CURRENT",
+    SENSOR_RIGHT_MOTOR_CURRENT:      "SENSOR_RIGHT_MOTOR_CURRENT",
+    SENSOR_MAIN_BRUSH_MOTOR_CURRENT: "SENSOR_MAIN_BRUSH_MOTOR_CURRENT",
+    SENSOR_SIDE_BRUSH_MOTOR_CURRENT: "SENSOR_SIDE_BRUSH_MOTOR_CURRENT",
+
+    SENSOR_GROUP_0:                  "SENSOR_GROUP_0",
+    SENSOR_GROUP_1:                  "SENSOR_GROUP_1",
+    SENSOR_GROUP_2:                  "SENSOR_GROUP_2",
+    SENSOR_GROUP_3:                  "SENSOR_GROUP_3",
+    SENSOR_GROUP_4:                  "SENSOR_GROUP_4",
+    SENSOR_GROUP_5:                  "SENSOR_GROUP_5",
+    SENSOR_GROUP_6:                  "SENSOR_GROUP_6",
+}
+
+// String returns the name of the sensor code's constant, e.g.
+// "SENSOR_VOLTAGE", or "SensorCode(n)" for unnamed codes.
+func (c SensorCode) String() string {
+    if name, ok := sensorNames[c]; ok {
+        return name
+    }
+    return fmt.Sprintf("SensorCode(%d)", byte(c))
+}
+
+// SENSOR_GROUP_* constants define the packet IDs of sensor groups. Requesting
+// a group returns the values of all of its packets, in order.
+const (
+    // Packets 7-26.
+    SENSOR_GROUP_0 = SensorCode(iota)
+
+    // Packets 7-16.
+    SENSOR_GROUP_1
+
+    // Packets 17-20.
+    SENSOR_GROUP_2
+
+    // Packets 21-26.
+    SENSOR_GROUP_3
+
+    // Packets 27-34.
+    SENSOR_GROUP_4
+
+    // Packets 35-42.
+    SENSOR_GROUP_5
+
+    // Packets 7-42, all of the above.
+    SENSOR_GROUP_6
+)
+
+// sensorRange returns the packet IDs from first to last inclusive.
+func sensorRange(first, last SensorCode) []SensorCode {
+    var ids []SensorCode
+    for id := first; id <= last; id++ {
+        ids = append(ids, id)
+    }
+    return ids
+}
+
+// SENSOR_GROUP_PACKETS is a map[SensorCode][]SensorCode that defines the
+// packets contained in each sensor group, in the order they are sent.
+var SENSOR_GROUP_PACKETS = map[SensorCode][]SensorCode{
+    SENSOR_GROUP_0: sensorRange(SENSOR_BUMP_WHEELS_DROPS, SENSOR_BATTERY_CAPACITY),
+    SENSOR_GROUP_1: sensorRange(SENSOR_BUMP_WHEELS_DROPS, 16),
+    SENSOR_GROUP_2: sensorRange(SENSOR_IR_OMNI, SENSOR_ANGLE),
+    SENSOR_GROUP_3: sensorRange(SENSOR_CHARGING, SENSOR_BATTERY_CAPACITY),
+    SENSOR_GROUP_4: sensorRange(SENSOR_WALL_SIGNAL, SENSOR_CHARGING_SOURCE),
+    SENSOR_GROUP_5: sensorRange(SENSOR_OI_MODE, SENSOR_LEFT_VELOCITY),
+    SENSOR_GROUP_6: sensorRange(SENSOR_BUMP_WHEELS_DROPS, SENSOR_LEFT_VELOCITY),
+}
+
 // SENSOR_PACKET_LENGTH is a map[SensorCode]byte that defines the length in bytes of sensor data packets.
 var SENSOR_PACKET_LENGTH = map[SensorCode]byte{
     SENSOR_BUMP_WHEELS_DROPS:        1,
@@ -226,11 +466,32 @@ var SENSOR_PACKET_LENGTH = map[SensorCode]byte{
     SENSOR_REQUESTED_RADIUS:         2,
     SENSOR_RIGHT_VELOCITY:           2,
     SENSOR_LEFT_VELOCITY:            2,
-    0:                               26,
-    1:                               10,
-    2:                               6,
-    3:                               10,
-    4:                               14,
-    5:                               12,
-    6:                               52,
+
+    // Encoder and stasis sensors.
+    SENSOR_LEFT_ENCODER_COUNTS:  2,
+    SENSOR_RIGHT_ENCODER_COUNTS: 2,
+    SENSOR_STASIS:               1,
+
+    // Light bump sensors.
+    SENSOR_LIGHT_BUMPER:                   1,
+    SENSOR_LIGHT_BUMP_LEFT_SIGNAL:         2,
+    SENSOR_LIGHT_BUMP_FRONT_LEFT_SIGNAL:   2,
+    SENSOR_LIGHT_BUMP_CENTER_LEFT_SIGNAL:  2,
+    SENSOR_LIGHT_BUMP_CENTER_RIGHT_SIGNAL: 2,
+    SENSOR_LIGHT_BUMP_FRONT_RIGHT_SIGNAL:  2,
+    SENSOR_LIGHT_BUMP_RIGHT_SIGNAL:        2,
+
+    // Motor current sensors.
+    SENSOR_LEFT_MOTOR_CURRENT:       2,
+    SENSOR_RIGHT_MOTOR_CURRENT:      2,
+    SENSOR_MAIN_BRUSH_MOTOR_CURRENT: 2,
+    SENSOR_SIDE_BRUSH_MOTOR_CURRENT: 2,
+
+    SENSOR_GROUP_0:                  26,
+    SENSOR_GROUP_1:                  10,
+    SENSOR_GROUP_2:                  6,
+    SENSOR_GROUP_3:                  10,
+    SENSOR_GROUP_4:                  14,
+    SENSOR_GROUP_5:                  12,
+    SENSOR_GROUP_6:                  52,
 }
@@ -0,0 +1,45 @@
+package constants_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+func TestSensorCodeString(t *testing.T) {
+	names := map[constants.SensorCode]string{
+		constants.SENSOR_BUMP_WHEELS_DROPS: "SENSOR_BUMP_WHEELS_DROPS",
+		constants.SENSOR_VOLTAGE:           "SENSOR_VOLTAGE",
+		constants.SENSOR_LEFT_VELOCITY:     "SENSOR_LEFT_VELOCITY",
+		constants.SENSOR_GROUP_6:           "SENSOR_GROUP_6",
+		15:                                 "SensorCode(15)",
+		200:                                "SensorCode(200)",
+	}
+	for code, expected := range names {
+		if actual := code.String(); actual != expected {
+			t.Errorf("code %d: got name %q, expected %q", byte(code), actual, expected)
+		}
+	}
+	if s := fmt.Sprintf("%v", constants.SENSOR_OI_MODE); s != "SENSOR_OI_MODE" {
+		t.Errorf("%%v of SENSOR_OI_MODE formatted as %q", s)
+	}
+}
+
+func TestOpCodeString(t *testing.T) {
+	names := map[constants.OpCode]string{
+		constants.Start:     "Start",
+		constants.Drive:     "Drive",
+		constants.Motors:    "LowSideDrivers",
+		constants.WaitEvent: "WaitEvent",
+		constants.Reset:     "Reset",
+		constants.Power:     "Power",
+		200:                 "OpCode(200)",
+		255:                 "OpCode(255)",
+	}
+	for opcode, expected := range names {
+		if actual := opcode.String(); actual != expected {
+			t.Errorf("opcode %d: got name %q, expected %q", byte(opcode), actual, expected)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package roomba
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// chargePollInterval is the time between charging state queries in
+// WaitForCharging, NotifyWhenCharged, OnChargingFault and the temperature
+// queries in MonitorTemperature.
+const chargePollInterval = 200 * time.Millisecond
+
+// newTicker returns the channel of a new time.Ticker and a function stopping
+// it. Tests replace it to control the polling.
+var newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// waitFor reads the sensor packet right away and then every interval until
+// pred returns true for its value. It returns the first read error, or
+// ctx.Err() once ctx is done.
+func (roomba *Roomba) waitFor(ctx context.Context, packet constants.SensorCode, interval time.Duration, pred func(value []byte) bool) error {
+	ticks, stop := newTicker(interval)
+	defer stop()
+	for {
+		v, err := roomba.SensorsContext(ctx, packet)
+		if err != nil {
+			return err
+		}
+		if pred(v) {
+			return nil
+		}
+		select {
+		case <-ticks:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll reads the sensor packet every interval and calls fn with its value
+// until ctx is done or fn returns false. Failed reads are logged and retried
+// on the next tick.
+func (roomba *Roomba) poll(ctx context.Context, interval time.Duration, packet constants.SensorCode, fn func(value []byte) bool) {
+	ticks, stop := newTicker(interval)
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+		}
+		v, err := roomba.SensorsContext(ctx, packet)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("%v query failed: %v", packet, err)
+			}
+			continue
+		}
+		if !fn(v) {
+			return
+		}
+	}
+}
+
+// isCharging reports whether the charging state means the robot is on a
+// charger.
+func isCharging(state ChargingState) bool {
+	switch state {
+	case ReconditioningCharging, FullCharging, TrickleCharging, Waiting:
+		return true
+	}
+	return false
+}
+
+// WaitForCharging polls the charging state until the robot is on a charger or
+// ctx is done.
+func (roomba *Roomba) WaitForCharging(ctx context.Context) error {
+	return roomba.waitFor(ctx, constants.SENSOR_CHARGING, chargePollInterval, func(state []byte) bool {
+		return isCharging(ChargingState(state[0]))
+	})
+}
+
+// DockAndSleep sends the robot to its dock, waits for it to start charging
+// and powers it down, ending the session.
+func (roomba *Roomba) DockAndSleep(ctx context.Context) error {
+	if err := roomba.SeekDock(); err != nil {
+		return err
+	}
+	if err := roomba.WaitForCharging(ctx); err != nil {
+		return err
+	}
+	return roomba.Power()
+}
+
+// NotifyWhenCharged polls the charging state every chargePollInterval and
+// plays the given song once full charging completes on the dock, that is when
+// the state changes from FullCharging to TrickleCharging or NotCharging while
+// the home base is the charging source. Leaving the dock while charging isn't
+// a completed charge. It returns immediately and gives up when ctx is done.
+func (roomba *Roomba) NotifyWhenCharged(ctx context.Context, songNumber byte) {
+	wasFull := false
+	go roomba.poll(ctx, chargePollInterval, constants.SENSOR_CHARGING, func(state []byte) bool {
+		switch ChargingState(state[0]) {
+		case FullCharging:
+			wasFull = true
+		case TrickleCharging, NotCharging:
+			if !wasFull {
+				break
+			}
+			wasFull = false
+			sources, err := roomba.SensorsContext(ctx, constants.SENSOR_CHARGING_SOURCE)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("charging source query failed: %v", err)
+				}
+				break
+			}
+			if !decodeChargingSources(sources[0]).HomeBase {
+				log.Printf("left the dock before the charge completed")
+				break
+			}
+			if err := roomba.Play(songNumber); err != nil {
+				log.Printf("failed playing charged notification: %v", err)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+// MonitorTemperature polls the battery temperature every chargePollInterval
+// and calls fn with it when it rises above maxC °C, e.g. to stop an
+// unattended charge of an overheating battery. fn is called again only after
+// the temperature went back to maxC or below. It returns immediately and
+// stops when ctx is done.
+func (roomba *Roomba) MonitorTemperature(ctx context.Context, maxC int8, fn func(int8)) {
+	hot := false
+	go roomba.poll(ctx, chargePollInterval, constants.SENSOR_TEMPERATURE, func(v []byte) bool {
+		temperature := int8(v[0])
+		if temperature > maxC && !hot {
+			log.Printf("battery temperature %d °C is above %d °C", temperature, maxC)
+			fn(temperature)
+		}
+		hot = temperature > maxC
+		return true
+	})
+}
+
+// DockBeacon tells which of the dock's IR beacons the robot sees. The red
+// buoy is on the dock's left and the green buoy on its right, seen from the
+// front, and the force field surrounds the dock.
+type DockBeacon struct {
+	ForceField bool
+	GreenBuoy  bool
+	RedBuoy    bool
+}
+
+// decodeDockBeacon decodes an IR character sent by a Roomba 600 or Roomba 500
+// dock. Other characters decode to no beacons.
+func decodeDockBeacon(ir byte) DockBeacon {
+	var forceField byte
+	switch ir &^ 0x0f {
+	case 160: // Roomba 600 dock.
+		forceField = 1
+	case 240: // Roomba 500 dock.
+		forceField = 2
+	default:
+		return DockBeacon{}
+	}
+	if ir&0x0f&^(forceField|4|8) != 0 {
+		return DockBeacon{}
+	}
+	return DockBeacon{
+		ForceField: ir&forceField != 0,
+		GreenBuoy:  ir&4 != 0,
+		RedBuoy:    ir&8 != 0,
+	}
+}
+
+// DockBeacon reads the omnidirectional IR receiver and decodes which dock
+// beacons it sees, e.g. for custom docking logic.
+func (roomba *Roomba) DockBeacon() (DockBeacon, error) {
+	ir, err := roomba.Sensors(constants.SENSOR_IR_OMNI)
+	if err != nil {
+		return DockBeacon{}, err
+	}
+	return decodeDockBeacon(ir[0]), nil
+}
@@ -0,0 +1,130 @@
+package roomba_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestDockAndSleep(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorSequence(constants.SENSOR_CHARGING, [][]byte{{0}, {0}, {3}})
+	ticks := make(chan time.Time)
+	defer roomba.SetTicker(ticks)()
+	// The state is read right away and after each of the two ticks.
+	go func() {
+		ticks <- time.Now()
+		ticks <- time.Now()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.DockAndSleep(ctx); err != nil {
+		t.Fatalf("DockAndSleep failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{143, 142, 21, 142, 21, 142, 21, 133}, t)
+}
+
+func TestDockAndSleepTimeout(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_CHARGING, []byte{0})
+	ticks := make(chan time.Time)
+	defer roomba.SetTicker(ticks)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := r.DockAndSleep(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestNotifyWhenCharged(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	ticks := make(chan time.Time)
+	defer roomba.SetTicker(ticks)()
+	// Full charging ends once off the dock, then once on the dock.
+	rt.SetSensorSequence(constants.SENSOR_CHARGING, [][]byte{{2}, {0}, {2}, {3}})
+	rt.SetSensorSequence(constants.SENSOR_CHARGING_SOURCE, [][]byte{{0}, {2}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.NotifyWhenCharged(ctx, 3)
+	for i := 0; i < 4; i++ {
+		ticks <- time.Now()
+	}
+	// It stops polling once it played the song.
+	select {
+	case ticks <- time.Now():
+		t.Errorf("still polling after the notification")
+	case <-time.After(100 * time.Millisecond):
+	}
+	rt.VerifyWritten(r, []byte{142, 21, 142, 21, 142, 34, 142, 21, 142, 21, 142, 34, 141, 3}, t)
+}
+
+func TestMonitorTemperature(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorSequence(constants.SENSOR_TEMPERATURE, [][]byte{{40}, {52}, {55}, {45}, {51}})
+
+	ticks := make(chan time.Time)
+	defer roomba.SetTicker(ticks)()
+
+	temperatures := make(chan int8, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	r.MonitorTemperature(ctx, 50, func(c int8) { temperatures <- c })
+	// The sixth tick is taken once the fifth value was handled.
+	for i := 0; i < 6; i++ {
+		ticks <- time.Now()
+	}
+	cancel()
+
+	var fired []int8
+	for len(temperatures) > 0 {
+		fired = append(fired, <-temperatures)
+	}
+	if len(fired) != 2 || fired[0] != 52 || fired[1] != 51 {
+		t.Errorf("fn called with %v, expected [52 51]", fired)
+	}
+}
+
+func TestDockBeacon(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	cases := map[byte]roomba.DockBeacon{
+		0:   {},
+		129: {},
+		160: {},
+		161: {ForceField: true},
+		164: {GreenBuoy: true},
+		165: {GreenBuoy: true, ForceField: true},
+		168: {RedBuoy: true},
+		169: {RedBuoy: true, ForceField: true},
+		172: {RedBuoy: true, GreenBuoy: true},
+		173: {RedBuoy: true, GreenBuoy: true, ForceField: true},
+		240: {},
+		242: {ForceField: true},
+		244: {GreenBuoy: true},
+		246: {GreenBuoy: true, ForceField: true},
+		248: {RedBuoy: true},
+		250: {RedBuoy: true, ForceField: true},
+		252: {RedBuoy: true, GreenBuoy: true},
+		254: {RedBuoy: true, GreenBuoy: true, ForceField: true},
+	}
+	for ir, expected := range cases {
+		rt.SetSensorValue(constants.SENSOR_IR_OMNI, []byte{ir})
+		b, err := r.DockBeacon()
+		if err != nil {
+			t.Fatalf("error reading dock beacon: %s", err)
+		}
+		if b != expected {
+			t.Errorf("IR character %d decoded as %+v, expected %+v", ir, b, expected)
+		}
+	}
+}
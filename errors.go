@@ -0,0 +1,63 @@
+// Error values returned by the driver.
+
+package roomba
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// Protocol failures. Errors returned by the driver wrap these, so they should
+// be checked with errors.Is.
+var (
+	// ErrChecksumMismatch means a stream frame failed checksum verification.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrShortRead means the port returned fewer bytes than the command
+	// replies with.
+	ErrShortRead = errors.New("short read")
+	// ErrUnknownPacket means the sensor packet id isn't in
+	// constants.SENSOR_PACKET_LENGTH.
+	ErrUnknownPacket = errors.New("unknown packet id")
+	// ErrInvalidMode means the command isn't accepted in the current OI mode.
+	ErrInvalidMode = errors.New("invalid mode")
+	// ErrInvalidFrame means a stream frame has a wrong header or N-bytes.
+	ErrInvalidFrame = errors.New("invalid stream frame")
+	// ErrFrameLength means the N-bytes of a stream frame disagrees with the
+	// length of the requested packets, e.g. because a length in
+	// constants.SENSOR_PACKET_LENGTH is wrong for the robot's firmware.
+	ErrFrameLength = errors.New("stream frame length mismatch")
+	// ErrFrameTooLarge means the frames of a requested stream wouldn't fit
+	// in the 255 bytes allowed by the OI.
+	ErrFrameTooLarge = errors.New("stream frame too large")
+	// ErrReadOnly means a command was written to a read-only Roomba.
+	ErrReadOnly = errors.New("read-only connection")
+	// ErrObstacle means a maneuver was stopped because a bumper was pressed
+	// or a cliff was detected.
+	ErrObstacle = errors.New("obstacle detected")
+	// ErrStopped means a drive command was refused because EmergencyStop was
+	// called and neither Safe nor Full was called since.
+	ErrStopped = errors.New("stopped by EmergencyStop")
+	// ErrOdometryCapped means the distance or angle sensor reported the
+	// limit of its range, so the travel since the previous read is unknown.
+	ErrOdometryCapped = errors.New("odometry capped")
+	// ErrOITimedOut means a sensor read or a command failed, or a reply was
+	// all zeros, after the robot was left without commands long enough for
+	// the OI to go to sleep. Sending Start wakes it up.
+	ErrOITimedOut = errors.New("OI likely asleep; send Start")
+)
+
+// PacketError records a failure concerning a single sensor packet.
+type PacketError struct {
+	PacketId constants.SensorCode
+	Err      error
+}
+
+func (e *PacketError) Error() string {
+	return fmt.Sprintf("sensor packet %d: %v", e.PacketId, e.Err)
+}
+
+func (e *PacketError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,122 @@
+package roomba_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+// eofPort accepts all writes and returns EOF on every read, like a robot
+// that hung up.
+type eofPort struct {
+	bytes.Buffer
+}
+
+func (p *eofPort) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func TestUnknownPacketError(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	_, err := r.Sensors(constants.SensorCode(99))
+	if !errors.Is(err, roomba.ErrUnknownPacket) {
+		t.Errorf("expected ErrUnknownPacket, got %v", err)
+	}
+	var packetErr *roomba.PacketError
+	if !errors.As(err, &packetErr) || packetErr.PacketId != 99 {
+		t.Errorf("expected PacketError for packet 99, got %v", err)
+	}
+
+	_, err = r.QueryList([]constants.SensorCode{constants.SENSOR_WALL, 99})
+	if !errors.Is(err, roomba.ErrUnknownPacket) {
+		t.Errorf("expected ErrUnknownPacket from QueryList, got %v", err)
+	}
+	_, err = r.Stream([]constants.SensorCode{99})
+	if !errors.Is(err, roomba.ErrUnknownPacket) {
+		t.Errorf("expected ErrUnknownPacket from Stream, got %v", err)
+	}
+}
+
+func TestShortReadError(t *testing.T) {
+	r := &roomba.Roomba{S: &eofPort{}}
+	_, err := r.Sensors(constants.SENSOR_VOLTAGE)
+	if !errors.Is(err, roomba.ErrShortRead) {
+		t.Errorf("expected ErrShortRead, got %v", err)
+	}
+	var packetErr *roomba.PacketError
+	if !errors.As(err, &packetErr) || packetErr.PacketId != constants.SENSOR_VOLTAGE {
+		t.Errorf("expected PacketError for voltage packet, got %v", err)
+	}
+}
+
+func TestOITimedOutError(t *testing.T) {
+	// The sleeping OI sends a stray byte instead of the 2 voltage bytes.
+	port := struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader([]byte{0xff}), &bytes.Buffer{}}
+	r := &roomba.Roomba{S: port}
+	roomba.SetLastWrite(r, time.Now().Add(-6*time.Minute))
+	_, err := r.Sensors(constants.SENSOR_VOLTAGE)
+	if !errors.Is(err, roomba.ErrOITimedOut) {
+		t.Errorf("expected ErrOITimedOut after 6 minutes idle, got %v", err)
+	}
+
+	// The same failure right after a command is just a short read.
+	_, err = r.Sensors(constants.SENSOR_VOLTAGE)
+	if !errors.Is(err, roomba.ErrShortRead) || errors.Is(err, roomba.ErrOITimedOut) {
+		t.Errorf("expected ErrShortRead, got %v", err)
+	}
+}
+
+func TestOITimedOutZeros(t *testing.T) {
+	// The sleeping OI sends zeros instead of the voltage.
+	port := struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader([]byte{0, 0, 0, 0}), &bytes.Buffer{}}
+	r := &roomba.Roomba{S: port}
+	roomba.SetLastWrite(r, time.Now().Add(-6*time.Minute))
+	_, err := r.Sensors(constants.SENSOR_VOLTAGE)
+	if !errors.Is(err, roomba.ErrOITimedOut) {
+		t.Errorf("expected ErrOITimedOut for zeros after 6 minutes idle, got %v", err)
+	}
+
+	// Zeros right after a command are a valid reply.
+	if v, err := r.Sensors(constants.SENSOR_VOLTAGE); err != nil || !bytes.Equal(v, []byte{0, 0}) {
+		t.Errorf("reading zeros returned %v, %v", v, err)
+	}
+}
+
+func TestOITimedOutWriteFailed(t *testing.T) {
+	r := &roomba.Roomba{S: &brokenPort{}}
+	roomba.SetLastWrite(r, time.Now().Add(-6*time.Minute))
+	if err := r.Safe(); !errors.Is(err, roomba.ErrOITimedOut) {
+		t.Errorf("expected ErrOITimedOut for a failed command after 6 minutes idle, got %v", err)
+	}
+	if err := r.Safe(); err == nil || errors.Is(err, roomba.ErrOITimedOut) {
+		t.Errorf("expected a plain write error, got %v", err)
+	}
+}
+
+func TestOITimedOutStream(t *testing.T) {
+	// The OI went to sleep while streaming and sends garbage.
+	port := struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0xff}), &bytes.Buffer{}}
+	r := &roomba.Roomba{S: port}
+	roomba.SetLastWrite(r, time.Now().Add(-6*time.Minute))
+	err := r.ReadStream([]constants.SensorCode{constants.SENSOR_VIRTUAL_WALL}, make(chan [][]byte))
+	if !errors.Is(err, roomba.ErrOITimedOut) {
+		t.Errorf("expected ErrOITimedOut for a bad frame after 6 minutes idle, got %v", err)
+	}
+}
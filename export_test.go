@@ -0,0 +1,38 @@
+package roomba
+
+import (
+	"io"
+	"time"
+
+	"github.com/tarm/goserial"
+)
+
+// SetOpenPort replaces the function opening serial ports and returns a
+// function restoring it.
+func SetOpenPort(open func(name string, baud int) (io.ReadWriteCloser, error)) (restore func()) {
+	openPort = func(c *serial.Config) (io.ReadWriteCloser, error) {
+		return open(c.Name, c.Baud)
+	}
+	return func() { openPort = serial.OpenPort }
+}
+
+// SetLastWrite pretends the last command was written at t.
+func SetLastWrite(roomba *Roomba, t time.Time) {
+	roomba.writeMu.Lock()
+	defer roomba.writeMu.Unlock()
+	roomba.lastWrite = t
+}
+
+// SetTicker makes the polling loops tick when a value is sent to ticks and
+// returns a function restoring the tickers.
+func SetTicker(ticks <-chan time.Time) (restore func()) {
+	newTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() {}
+	}
+	return func() {
+		newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+			t := time.NewTicker(d)
+			return t.C, t.Stop
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Recording of the commands sent to the robot.
+
+package roomba
+
+import (
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// defaultHistorySize is the number of commands kept when HistorySize is 0.
+const defaultHistorySize = 32
+
+// CommandRecord is a command written to the port.
+type CommandRecord struct {
+	Opcode constants.OpCode
+	Data   []byte
+	Time   time.Time
+}
+
+// commandRing keeps the last commands written, overwriting the oldest.
+type commandRing struct {
+	records []CommandRecord
+	next    int // Index of the oldest record once the ring is full.
+}
+
+func (r *commandRing) add(record CommandRecord, size int) {
+	if cap(r.records) != size {
+		// First record, or the size changed: start over.
+		r.records = make([]CommandRecord, 0, size)
+		r.next = 0
+	}
+	if len(r.records) < size {
+		r.records = append(r.records, record)
+		return
+	}
+	r.records[r.next] = record
+	r.next = (r.next + 1) % size
+}
+
+func (roomba *Roomba) recordCommand(opcode constants.OpCode, p []byte, t time.Time) {
+	size := roomba.HistorySize
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	record := CommandRecord{opcode, append([]byte(nil), p...), t}
+	roomba.mu.Lock()
+	defer roomba.mu.Unlock()
+	roomba.history.add(record, size)
+}
+
+// CommandHistory returns the last commands written to the port while
+// RecordHistory was set, oldest first.
+func (roomba *Roomba) CommandHistory() []CommandRecord {
+	roomba.mu.Lock()
+	defer roomba.mu.Unlock()
+	h := roomba.history
+	records := make([]CommandRecord, 0, len(h.records))
+	records = append(records, h.records[h.next:]...)
+	return append(records, h.records[:h.next]...)
+}
+
+// LastCommand returns the opcode and data of the last command written to the
+// port while RecordHistory was set, e.g. for checking what a test wrote to a
+// fake transport. It returns 0 and nil if no command was recorded.
+func (roomba *Roomba) LastCommand() (constants.OpCode, []byte) {
+	roomba.mu.Lock()
+	defer roomba.mu.Unlock()
+	h := roomba.history
+	if len(h.records) == 0 {
+		return 0, nil
+	}
+	last := h.records[(h.next+len(h.records)-1)%len(h.records)]
+	return last.Opcode, append([]byte(nil), last.Data...)
+}
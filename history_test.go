@@ -0,0 +1,65 @@
+package roomba_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestCommandHistory(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	r.Start()
+	if h := r.CommandHistory(); len(h) != 0 {
+		t.Errorf("commands recorded without RecordHistory: %v", h)
+	}
+
+	r.RecordHistory = true
+	r.HistorySize = 3
+	defer func() { r.RecordHistory = false }()
+	r.Safe()
+	r.Drive(100, 0)
+	r.Motors(1)
+	r.Play(2)
+
+	expected := []struct {
+		opcode constants.OpCode
+		data   []byte
+	}{
+		{constants.Drive, []byte{0, 100, 0, 0}},
+		{constants.Motors, []byte{1}},
+		{constants.Play, []byte{2}},
+	}
+	h := r.CommandHistory()
+	if len(h) != len(expected) {
+		t.Fatalf("history has %d commands, expected %d: %v", len(h), len(expected), h)
+	}
+	for i, e := range expected {
+		if h[i].Opcode != e.opcode || !bytes.Equal(h[i].Data, e.data) {
+			t.Errorf("command %d is %v %v, expected %v %v", i, h[i].Opcode, h[i].Data, e.opcode, e.data)
+		}
+		if i > 0 && h[i].Time.Before(h[i-1].Time) {
+			t.Errorf("command %d was recorded before the previous one", i)
+		}
+	}
+}
+
+func TestLastCommand(t *testing.T) {
+	r, _ := rt.MakeFakeRoomba()
+	if opcode, data := r.LastCommand(); opcode != 0 || data != nil {
+		t.Errorf("last command is %v %v before any was recorded", opcode, data)
+	}
+
+	r.RecordHistory = true
+	r.HistorySize = 2
+	r.Start()
+	r.Safe()
+	r.Drive(-200, 500)
+	opcode, data := r.LastCommand()
+	if opcode != constants.Drive || !bytes.Equal(data, []byte{255, 56, 1, 244}) {
+		t.Errorf("last command is %v %v, expected Drive [255 56 1 244]", opcode, data)
+	}
+}
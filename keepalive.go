@@ -0,0 +1,84 @@
+// Keeps the Open Interface from going to sleep.
+
+package roomba
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// oiSleepTimeout is how long the OI goes without commands before it may
+// sleep.
+const oiSleepTimeout = 5 * time.Minute
+
+// idleTime returns the time since the last command was written, or 0 if none
+// was.
+func (roomba *Roomba) idleTime() time.Duration {
+	roomba.writeMu.Lock()
+	defer roomba.writeMu.Unlock()
+	if roomba.lastWrite.IsZero() {
+		return 0
+	}
+	return time.Since(roomba.lastWrite)
+}
+
+// checkAsleep returns an error wrapping ErrOITimedOut instead of err if the
+// read failed after idle, the time without commands before its request, was
+// long enough for the OI to go to sleep. The sleeping OI doesn't answer, or
+// sends garbage, which would otherwise be reported as a short read or a bad
+// frame.
+func checkAsleep(idle time.Duration, err error) error {
+	if idle < oiSleepTimeout {
+		return err
+	}
+	if errors.Is(err, ErrShortRead) || errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrInvalidFrame) {
+		return asleep(idle, err)
+	}
+	return err
+}
+
+// checkAsleepWrite returns an error wrapping ErrOITimedOut instead of err if
+// writing a command failed after idle was long enough for the robot to go to
+// sleep, or to power down and take the serial adapter with it.
+func checkAsleepWrite(idle time.Duration, err error) error {
+	if err == nil || idle < oiSleepTimeout {
+		return err
+	}
+	return asleep(idle, err)
+}
+
+// checkAsleepReply returns an error wrapping ErrOITimedOut if the reply read
+// after idle is all zeros and idle was long enough for the OI to go to
+// sleep. The sleeping OI may send zeros instead of the values.
+func checkAsleepReply(idle time.Duration, reply ...[]byte) error {
+	if idle < oiSleepTimeout {
+		return nil
+	}
+	for _, value := range reply {
+		for _, b := range value {
+			if b != 0 {
+				return nil
+			}
+		}
+	}
+	return asleep(idle, errors.New("reply is all zeros"))
+}
+
+// asleep wraps ErrOITimedOut, keeping the error that revealed the sleep in
+// the message.
+func asleep(idle time.Duration, err error) error {
+	return fmt.Errorf("%w: no commands for %v: %v", ErrOITimedOut, idle.Round(time.Second), err)
+}
+
+// StartKeepAlive queries the OI mode every interval until ctx is done. The OI
+// of 500-series Roombas drops out of Safe and Full modes after about 5 minutes
+// without commands, and the robot may power down. The queries run in the
+// background and are serialized with other sensor reads, but shouldn't be
+// combined with a running Stream.
+func (roomba *Roomba) StartKeepAlive(ctx context.Context, interval time.Duration) {
+	go roomba.poll(ctx, interval, constants.SENSOR_OI_MODE, func([]byte) bool { return true })
+}
@@ -0,0 +1,20 @@
+package roomba_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestKeepAlive(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.StartKeepAlive(ctx, 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	rt.VerifyWritten(r, []byte{142, 35, 142, 35, 142, 35}, t)
+}
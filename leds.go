@@ -0,0 +1,132 @@
+// Helpers for the LED bits and the Clean/Power LED color.
+
+package roomba
+
+import "github.com/infinities-within/go-roomba/constants"
+
+// Bits of the first data byte of the LEDs command. Create's Play and Advance
+// LEDs share their bits with the Roomba's Spot and Check Robot LEDs.
+const (
+	ledDebris  = 1 << 0
+	ledSpot    = 1 << 1
+	ledPlay    = ledSpot
+	ledDock    = 1 << 2
+	ledCheck   = 1 << 3
+	ledAdvance = ledCheck
+)
+
+// EncodeLEDBits returns the LED bits byte of the LEDs command. advance and
+// play are the names of the check and spot LEDs on Create, so either one of
+// a pair turns on the same LED.
+func EncodeLEDBits(advance, play, debris, spot, dock, check bool) byte {
+	var b byte
+	for _, led := range []struct {
+		on  bool
+		bit byte
+	}{
+		{advance, ledAdvance},
+		{play, ledPlay},
+		{debris, ledDebris},
+		{spot, ledSpot},
+		{dock, ledDock},
+		{check, ledCheck},
+	} {
+		if led.on {
+			b |= led.bit
+		}
+	}
+	return b
+}
+
+// DecodeLEDBits is the inverse of EncodeLEDBits. Since advance and check, as
+// well as play and spot, share a bit, each pair is decoded to the same value.
+func DecodeLEDBits(b byte) (advance, play, debris, spot, dock, check bool) {
+	return b&ledAdvance != 0, b&ledPlay != 0, b&ledDebris != 0,
+		b&ledSpot != 0, b&ledDock != 0, b&ledCheck != 0
+}
+
+// LEDColor is the color of the Clean/Power LED. The LED can only mix green
+// and red: 0 is green, 255 is red and intermediate values are intermediate
+// colors.
+type LEDColor byte
+
+// Named Clean/Power LED colors. They're the colors LEDColorFromRGB returns
+// for their RGB values.
+const (
+	Green  LEDColor = 0
+	Yellow LEDColor = 127 // RGB 255, 255, 0.
+	Orange LEDColor = 154 // RGB 255, 165, 0.
+	Red    LEDColor = 255
+)
+
+// LEDColorFromRGB returns the LED color closest to the given RGB color, based
+// on the proportion of red to green. Blue can't be shown and is ignored.
+func LEDColorFromRGB(r, g, b byte) LEDColor {
+	if r == 0 && g == 0 {
+		return Green
+	}
+	return LEDColor(255 * uint(r) / (uint(r) + uint(g)))
+}
+
+// LEDsColor works like LEDs, but takes a named power LED color.
+func (roomba *Roomba) LEDsColor(advance, play bool, color LEDColor, intensity byte) error {
+	return roomba.LEDs(advance, play, byte(color), intensity)
+}
+
+// ledState is the data of an LEDs command.
+type ledState struct {
+	bits      byte
+	color     byte
+	intensity byte
+}
+
+// writeLEDs sends an LEDs command and remembers it. The caller holds ledMu.
+func (roomba *Roomba) writeLEDs(s ledState) error {
+	if err := roomba.Command(constants.LEDs, s.bits, s.color, s.intensity); err != nil {
+		return err
+	}
+	roomba.leds = s
+	return nil
+}
+
+// flashLEDs sends an LEDs command without remembering it, so that restoreLEDs
+// brings back the LEDs as last set, including changes made in the meantime.
+func (roomba *Roomba) flashLEDs(s ledState) error {
+	roomba.ledMu.Lock()
+	defer roomba.ledMu.Unlock()
+	return roomba.Command(constants.LEDs, s.bits, s.color, s.intensity)
+}
+
+// restoreLEDs sends the last LEDs command again, e.g. after flashLEDs.
+func (roomba *Roomba) restoreLEDs() error {
+	roomba.ledMu.Lock()
+	defer roomba.ledMu.Unlock()
+	return roomba.writeLEDs(roomba.leds)
+}
+
+// setLED turns a single LED on or off, keeping the others as they were set
+// by the last LEDs command.
+func (roomba *Roomba) setLED(bit byte, on bool) error {
+	roomba.ledMu.Lock()
+	defer roomba.ledMu.Unlock()
+	s := roomba.leds
+	if on {
+		s.bits |= bit
+	} else {
+		s.bits &^= bit
+	}
+	return roomba.writeLEDs(s)
+}
+
+// SetPlayLED turns the Play LED on or off without changing the other LEDs,
+// e.g. to use it as a status indicator. The LEDs are tracked from the LEDs
+// commands sent, and Safe turns them all off.
+func (roomba *Roomba) SetPlayLED(on bool) error {
+	return roomba.setLED(ledPlay, on)
+}
+
+// SetAdvanceLED turns the Advance LED on or off without changing the other
+// LEDs, see SetPlayLED.
+func (roomba *Roomba) SetAdvanceLED(on bool) error {
+	return roomba.setLED(ledAdvance, on)
+}
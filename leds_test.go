@@ -0,0 +1,92 @@
+package roomba_test
+
+import (
+	"testing"
+
+	"github.com/infinities-within/go-roomba"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestLEDColors(t *testing.T) {
+	colors := map[roomba.LEDColor]byte{
+		roomba.Green:  0,
+		roomba.Yellow: 127,
+		roomba.Orange: 154,
+		roomba.Red:    255,
+	}
+	for color, expected := range colors {
+		if byte(color) != expected {
+			t.Errorf("color %d doesn't match expected byte %d", color, expected)
+		}
+	}
+
+	rgb := []struct {
+		r, g, b  byte
+		expected roomba.LEDColor
+	}{
+		{0, 255, 0, roomba.Green},
+		{255, 0, 0, roomba.Red},
+		{255, 255, 0, roomba.Yellow},
+		{255, 165, 0, roomba.Orange},
+		{0, 0, 255, roomba.Green},
+	}
+	for _, c := range rgb {
+		if actual := roomba.LEDColorFromRGB(c.r, c.g, c.b); actual != c.expected {
+			t.Errorf("LEDColorFromRGB(%d, %d, %d) = %d, expected %d",
+				c.r, c.g, c.b, actual, c.expected)
+		}
+	}
+}
+
+func TestLEDsColor(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.LEDsColor(true, false, roomba.Orange, 255)
+	rt.VerifyWritten(r, []byte{139, 8, 154, 255}, t)
+}
+
+func TestLEDBits(t *testing.T) {
+	cases := []struct {
+		advance, play, debris, spot, dock, check bool
+		expected                                 byte
+	}{
+		{expected: 0},
+		{advance: true, check: true, expected: 8},
+		{play: true, spot: true, expected: 2},
+		{debris: true, expected: 1},
+		{dock: true, expected: 4},
+		{true, true, true, true, true, true, 15},
+	}
+	for _, c := range cases {
+		b := roomba.EncodeLEDBits(c.advance, c.play, c.debris, c.spot, c.dock, c.check)
+		if b != c.expected {
+			t.Errorf("EncodeLEDBits(%+v) = %d, expected %d", c, b, c.expected)
+		}
+		advance, play, debris, spot, dock, check := roomba.DecodeLEDBits(b)
+		if advance != c.advance || play != c.play || debris != c.debris ||
+			spot != c.spot || dock != c.dock || check != c.check {
+			t.Errorf("DecodeLEDBits(%d) = %v %v %v %v %v %v, expected %+v",
+				b, advance, play, debris, spot, dock, check, c)
+		}
+	}
+}
+
+func TestSetPlayAndAdvanceLEDs(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.LEDsColor(false, false, roomba.Orange, 200)
+	r.SetPlayLED(true)
+	r.SetAdvanceLED(true)
+	r.SetPlayLED(false)
+	// Safe turns the LEDs off.
+	r.Safe()
+	r.SetAdvanceLED(true)
+	rt.VerifyWritten(r, []byte{
+		139, 0, 154, 200,
+		139, 2, 154, 200,
+		139, 10, 154, 200,
+		139, 8, 154, 200,
+		131,
+		139, 8, 0, 0,
+	}, t)
+}
@@ -0,0 +1,238 @@
+// Maneuvers measured with the distance and angle sensors.
+
+package roomba
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// movePollInterval is how often maneuvers read the odometry and the safety
+// sensors.
+const movePollInterval = 15 * time.Millisecond
+
+// obstacleSensors are read along with the odometry while maneuvering.
+var obstacleSensors = []constants.SensorCode{
+	constants.SENSOR_BUMP_WHEELS_DROPS,
+	constants.SENSOR_CLIFF_LEFT,
+	constants.SENSOR_CLIFF_FRONT_LEFT,
+	constants.SENSOR_CLIFF_FRONT_RIGHT,
+	constants.SENSOR_CLIFF_RIGHT,
+}
+
+// DriveDistance drives straight for distanceMM millimeters, backward if it's
+// negative, at the speed of velocity mm/s. It stops the robot and returns an
+// error wrapping ErrObstacle if a bumper is pressed or a cliff is detected on
+// the way, or ErrOdometryCapped if the distance read is at the limit of its
+// range, so the travel is unknown.
+func (roomba *Roomba) DriveDistance(distanceMM int, velocity int16) error {
+	v := abs16(velocity)
+	if distanceMM < 0 {
+		v = -v
+	}
+	return roomba.moveUntil(constants.SENSOR_DISTANCE, distanceMM, velocity, func() error {
+		return roomba.DirectDrive(v, v)
+	})
+}
+
+// TurnInPlace turns by the given number of degrees, counter-clockwise if
+// positive and clockwise if negative, with the wheels at velocity mm/s. Like
+// DriveDistance, it stops on obstacles.
+func (roomba *Roomba) TurnInPlace(degrees int, velocity int16) error {
+	radius := RadiusTurnCCW
+	if degrees < 0 {
+		radius = RadiusTurnCW
+	}
+	return roomba.moveUntil(constants.SENSOR_ANGLE, degrees, velocity, func() error {
+		return roomba.Drive(abs16(velocity), radius)
+	})
+}
+
+// DrivePolygon drives along a regular polygon with the given number of sides,
+// turning counter-clockwise at each corner. It stops at the first obstacle,
+// see DriveDistance.
+func (roomba *Roomba) DrivePolygon(sides int, sideLengthMM int, velocity int16) error {
+	if sides < 3 {
+		return fmt.Errorf("invalid number of sides: %d", sides)
+	}
+	for i := 0; i < sides; i++ {
+		if err := roomba.DriveDistance(sideLengthMM, velocity); err != nil {
+			return err
+		}
+		// Spread the rounding of 360/sides so the turns add up to a full
+		// circle.
+		turn := 360*(i+1)/sides - 360*i/sides
+		if err := roomba.TurnInPlace(turn, velocity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveUntil resets the odometry, calls start and then polls the given
+// odometry sensor until the travel adds up to target. The robot is stopped
+// when the target is reached or an obstacle is detected.
+func (roomba *Roomba) moveUntil(odometer constants.SensorCode, target int, velocity int16, start func() error) error {
+	if target == 0 {
+		return nil
+	}
+	if velocity == 0 {
+		return fmt.Errorf("invalid velocity: %d", velocity)
+	}
+	if err := roomba.ResetOdometry(); err != nil {
+		return err
+	}
+	if err := start(); err != nil {
+		return err
+	}
+	packetIds := append([]constants.SensorCode{odometer}, obstacleSensors...)
+	travel := 0
+	for {
+		time.Sleep(movePollInterval)
+		v, err := roomba.QueryList(packetIds)
+		if err != nil {
+			roomba.Stop()
+			return err
+		}
+		delta := decodeInt16(v[0])
+		if isCapped(delta) {
+			log.Printf("odometry likely capped: read %d from sensor %d", delta, odometer)
+			roomba.Stop()
+			return fmt.Errorf("%w: read %d after %d of %d; poll more often",
+				ErrOdometryCapped, delta, travel, target)
+		}
+		travel += int(delta)
+		if obstacle := detectObstacle(v[1:]); obstacle != "" {
+			roomba.Stop()
+			return fmt.Errorf("%w: %s after %d of %d", ErrObstacle, obstacle, travel, target)
+		}
+		if absInt(travel) >= absInt(target) {
+			return roomba.Stop()
+		}
+	}
+}
+
+// detectObstacle describes the obstacle reported by the values of
+// obstacleSensors, or returns "" if there's none.
+func detectObstacle(values [][]byte) string {
+	if b := decodeBumpsWheelDrops(values[0][0]); b.BumpLeft || b.BumpRight {
+		return "bumper pressed"
+	}
+	for i, cliff := range values[1:] {
+		if decodeFlag(cliff[0]) {
+			return fmt.Sprintf("cliff sensor %d triggered", obstacleSensors[i+1])
+		}
+	}
+	return ""
+}
+
+// Steering of DriveHeading.
+const (
+	// headingTolerance is the error in degrees within which the robot
+	// drives straight.
+	headingTolerance = 2.0
+	// Beyond headingTurnInPlace degrees, the robot turns in place.
+	headingTurnInPlace = 45.0
+	// headingGain is divided by the error in degrees to get the radius of
+	// the arc in millimeters, so the robot turns tighter when further off.
+	headingGain = 3000.0
+)
+
+// DriveHeading drives at velocity mm/s towards targetHeadingDeg, in degrees
+// counter-clockwise from the heading when it's called, and then holds that
+// heading, until ctx is done. The heading is tracked with the angle sensor:
+// far from the target, the robot turns in place, then it follows arcs whose
+// radius grows as the error shrinks, and drives straight once it's within
+// 2 degrees. It stops the robot and returns ctx.Err() when ctx is done, and
+// stops on obstacles like DriveDistance.
+func (roomba *Roomba) DriveHeading(ctx context.Context, velocity int16, targetHeadingDeg float64) error {
+	if velocity == 0 {
+		return fmt.Errorf("invalid velocity: %d", velocity)
+	}
+	if err := roomba.ResetOdometry(); err != nil {
+		return err
+	}
+	packetIds := append([]constants.SensorCode{constants.SENSOR_ANGLE}, obstacleSensors...)
+	ticker := time.NewTicker(movePollInterval)
+	defer ticker.Stop()
+	heading := 0
+	driving := false
+	var radius int16
+	for {
+		// Only send Drive when the steering changes.
+		if r := headingRadius(targetHeadingDeg-float64(heading), velocity); !driving || r != radius {
+			v := velocity
+			if r == RadiusTurnCW || r == RadiusTurnCCW {
+				v = abs16(velocity)
+			}
+			if err := roomba.Drive(v, r); err != nil {
+				roomba.Stop()
+				return err
+			}
+			driving, radius = true, r
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			roomba.Stop()
+			return ctx.Err()
+		}
+		v, err := roomba.QueryList(packetIds)
+		if err != nil {
+			roomba.Stop()
+			return err
+		}
+		delta := decodeInt16(v[0])
+		if isCapped(delta) {
+			log.Printf("odometry likely capped: read %d from sensor %d", delta, constants.SENSOR_ANGLE)
+			roomba.Stop()
+			return fmt.Errorf("%w: read %d at heading %d; poll more often",
+				ErrOdometryCapped, delta, heading)
+		}
+		heading += int(delta)
+		if obstacle := detectObstacle(v[1:]); obstacle != "" {
+			roomba.Stop()
+			return fmt.Errorf("%w: %s at heading %d of %.1f",
+				ErrObstacle, obstacle, heading, targetHeadingDeg)
+		}
+	}
+}
+
+// headingRadius returns the Drive radius that steers towards the heading when
+// off by errDeg degrees, counter-clockwise if positive, driving at velocity.
+func headingRadius(errDeg float64, velocity int16) int16 {
+	switch {
+	case math.Abs(errDeg) <= headingTolerance:
+		return RadiusStraight
+	case errDeg > headingTurnInPlace:
+		return RadiusTurnCCW
+	case errDeg < -headingTurnInPlace:
+		return RadiusTurnCW
+	}
+	// A positive radius turns counter-clockwise when driving forward and
+	// clockwise when backing up.
+	radius := math.Max(-2000, math.Min(2000, headingGain/errDeg))
+	if velocity < 0 {
+		radius = -radius
+	}
+	return int16(radius)
+}
+
+func abs16(v int16) int16 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,165 @@
+package roomba_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+// clearObstacles makes the simulator report no bumps or cliffs.
+func clearObstacles() {
+	rt.SetSensorValue(constants.SENSOR_BUMP_WHEELS_DROPS, []byte{0})
+	rt.SetSensorValue(constants.SENSOR_CLIFF_RIGHT, []byte{0})
+}
+
+// driveCommands returns the drive commands in the history of r.
+func driveCommands(r *roomba.Roomba) []roomba.CommandRecord {
+	var drives []roomba.CommandRecord
+	for _, c := range r.CommandHistory() {
+		if c.Opcode == constants.Drive || c.Opcode == constants.DriveDirect {
+			drives = append(drives, c)
+		}
+	}
+	return drives
+}
+
+func TestDrivePolygon(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	clearObstacles()
+	r.RecordHistory = true
+	r.HistorySize = 1000
+	defer func() { r.RecordHistory = false }()
+
+	if err := r.DrivePolygon(4, 100, 500); err != nil {
+		t.Fatalf("error driving square: %s", err)
+	}
+
+	side := []struct {
+		opcode constants.OpCode
+		data   []byte
+	}{
+		{constants.DriveDirect, []byte{1, 244, 1, 244}},
+		{constants.Drive, []byte{0, 0, 0, 0}},
+		{constants.Drive, []byte{1, 244, 0, 1}},
+		{constants.Drive, []byte{0, 0, 0, 0}},
+	}
+	drives := driveCommands(r)
+	if len(drives) != 4*len(side) {
+		t.Fatalf("got %d drive commands, expected %d: %v", len(drives), 4*len(side), drives)
+	}
+	for i, c := range drives {
+		e := side[i%len(side)]
+		if c.Opcode != e.opcode || !bytes.Equal(c.Data, e.data) {
+			t.Errorf("drive command %d is %v % d, expected %v % d", i, c.Opcode, c.Data, e.opcode, e.data)
+		}
+	}
+}
+
+// turnedDegrees integrates the Drive commands in drives until end with the
+// kinematic model of the simulator, returning the heading in degrees.
+func turnedDegrees(drives []roomba.CommandRecord, end time.Time) float64 {
+	const wheelBase = 235.0
+	heading := 0.0
+	for i, c := range drives {
+		until := end
+		if i+1 < len(drives) {
+			until = drives[i+1].Time
+		}
+		v := float64(int16(uint16(c.Data[0])<<8 | uint16(c.Data[1])))
+		r := int16(uint16(c.Data[2])<<8 | uint16(c.Data[3]))
+		var rate float64 // Radians per second.
+		switch r {
+		case 0, roomba.RadiusStraight, -32768:
+		case roomba.RadiusTurnCCW:
+			rate = 2 * v / wheelBase
+		case roomba.RadiusTurnCW:
+			rate = -2 * v / wheelBase
+		default:
+			rate = v / float64(r)
+		}
+		heading += rate * until.Sub(c.Time).Seconds() * 180 / math.Pi
+	}
+	return heading
+}
+
+func TestDriveHeading(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	clearObstacles()
+	r.RecordHistory = true
+	r.HistorySize = 1000
+	defer func() { r.RecordHistory = false }()
+
+	for _, target := range []float64{90, -30} {
+		before := len(driveCommands(r))
+		ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+		err := r.DriveHeading(ctx, 200, target)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("got error %v, expected context.DeadlineExceeded", err)
+		}
+		drives := driveCommands(r)[before:]
+		// The last command is the Stop.
+		last := drives[len(drives)-2]
+		if !bytes.Equal(last.Data, []byte{0, 200, 127, 255}) {
+			t.Errorf("heading %.0f: last drive command is % d, expected driving straight", target, last.Data)
+		}
+		stop := drives[len(drives)-1]
+		if stop.Time.Sub(last.Time) < 500*time.Millisecond {
+			t.Errorf("heading %.0f: held only for %v", target, stop.Time.Sub(last.Time))
+		}
+		if heading := turnedDegrees(drives, stop.Time); math.Abs(heading-target) > 5 {
+			t.Errorf("heading %.0f: turned %.1f degrees", target, heading)
+		}
+	}
+}
+
+func TestDriveHeadingInvalidVelocity(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	if err := r.DriveHeading(context.Background(), 0, 90); err == nil {
+		t.Errorf("expected an error for velocity 0")
+	}
+}
+
+func TestDrivePolygonInvalidSides(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	if err := r.DrivePolygon(2, 100, 500); err == nil {
+		t.Errorf("expected an error for 2 sides")
+	}
+}
+
+func TestDriveDistanceStopsOnBump(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	clearObstacles()
+	rt.SetSensorSequence(constants.SENSOR_BUMP_WHEELS_DROPS, [][]byte{{0}, {0}, {0}, {0}, {1}})
+
+	err := r.DriveDistance(1000, 200)
+	if !errors.Is(err, roomba.ErrObstacle) {
+		t.Fatalf("got error %v, expected ErrObstacle", err)
+	}
+	rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 0}, t)
+}
+
+func TestTurnInPlaceStopsOnCliff(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	clearObstacles()
+	rt.SetSensorSequence(constants.SENSOR_CLIFF_LEFT, [][]byte{{0}, {1}})
+
+	err := r.TurnInPlace(-90, 200)
+	if !errors.Is(err, roomba.ErrObstacle) {
+		t.Fatalf("got error %v, expected ErrObstacle", err)
+	}
+	rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 0}, t)
+}
@@ -0,0 +1,180 @@
+// Helpers for measuring the robot's movement.
+
+package roomba
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// ResetOdometry reads and discards the distance and angle sensors. Both
+// report the travel since they were last read, so it should be called before
+// starting a measured maneuver. Otherwise the first read includes everything
+// since the previous one.
+func (roomba *Roomba) ResetOdometry() error {
+	_, err := roomba.QueryList([]constants.SensorCode{
+		constants.SENSOR_DISTANCE,
+		constants.SENSOR_ANGLE})
+	return err
+}
+
+// EncoderCounts reads the cumulative wheel encoder counts of Roomba 600 and
+// Create 2 robots. Unlike distance and angle, the counts aren't reset when
+// read and aren't capped, they roll over. Differences between two reads
+// should therefore be computed with int16 arithmetic.
+func (roomba *Roomba) EncoderCounts() (left, right int16, err error) {
+	v, err := roomba.QueryList([]constants.SensorCode{
+		constants.SENSOR_LEFT_ENCODER_COUNTS,
+		constants.SENSOR_RIGHT_ENCODER_COUNTS})
+	if err != nil {
+		return 0, 0, err
+	}
+	return decodeInt16(v[0]), decodeInt16(v[1]), nil
+}
+
+// Stasis reports whether the stasis caster is turning, i.e. whether the robot
+// is actually moving forward. It returns an error if the sensor is disabled.
+func (roomba *Roomba) Stasis() (bool, error) {
+	v, err := roomba.Sensors(constants.SENSOR_STASIS)
+	if err != nil {
+		return false, err
+	}
+	if v[0]&2 != 0 {
+		return false, errors.New("stasis sensor is disabled")
+	}
+	return v[0]&1 != 0, nil
+}
+
+// Wheel geometry of Roomba 600 and Create 2 robots, used to convert encoder
+// counts.
+const (
+	encoderCountsPerRev = 508.8
+	wheelDiameter       = 72.0  // mm
+	wheelBase           = 235.0 // mm
+)
+
+// Pose is a position and heading relative to where the robot was when
+// tracking started.
+type Pose struct {
+	X, Y    float64 // mm, X along the initial heading.
+	Heading float64 // Radians, counter-clockwise.
+}
+
+// advance moves the pose by distance mm along the average heading of the
+// interval and turns it by dHeading radians.
+func (p *Pose) advance(distance, dHeading float64) {
+	heading := p.Heading + dHeading/2
+	p.X += distance * math.Cos(heading)
+	p.Y += distance * math.Sin(heading)
+	p.Heading += dHeading
+}
+
+// isCapped reports whether a distance or angle value is at the limit of its
+// range. The robot caps the values instead of rolling them over, so such a
+// value likely means the sensor wasn't read often enough and some travel was
+// lost.
+func isCapped(v int16) bool {
+	return v == math.MaxInt16 || v == math.MinInt16
+}
+
+// Odometer estimates the robot's pose by integrating the distance and angle
+// sensors. The zero value is ready to use.
+type Odometer struct {
+	pose Pose
+}
+
+// Update integrates the distance and angle read since the previous update and
+// returns the new pose. capped is set if either value is at the limit of its
+// range, in which case the pose is off by an unknown amount and the sensors
+// should be read more often.
+func (o *Odometer) Update(distanceMM, angleDeg int16) (pose Pose, capped bool) {
+	o.pose.advance(float64(distanceMM), float64(angleDeg)*math.Pi/180)
+	return o.pose, isCapped(distanceMM) || isCapped(angleDeg)
+}
+
+// Pose returns the current pose estimate.
+func (o *Odometer) Pose() Pose {
+	return o.pose
+}
+
+// TrackPose resets the odometry and then reads the distance and angle every
+// poll, feeding them to an Odometer, and sends the updated pose estimate to
+// the returned channel. The channel is closed once ctx is done. Read errors
+// are logged and the reading retried on the next poll, capped reads are
+// logged too.
+func (roomba *Roomba) TrackPose(ctx context.Context, poll time.Duration) (<-chan Pose, error) {
+	if err := roomba.ResetOdometry(); err != nil {
+		return nil, err
+	}
+	out := make(chan Pose)
+	go func() {
+		defer close(out)
+		ticks, stop := newTicker(poll)
+		defer stop()
+		var o Odometer
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticks:
+			}
+			v, err := roomba.QueryList([]constants.SensorCode{
+				constants.SENSOR_DISTANCE,
+				constants.SENSOR_ANGLE})
+			if err != nil {
+				log.Printf("odometry query failed: %v", err)
+				continue
+			}
+			distance, angle := decodeInt16(v[0]), decodeInt16(v[1])
+			pose, capped := o.Update(distance, angle)
+			if capped {
+				log.Printf("odometry likely capped: distance %d, angle %d", distance, angle)
+			}
+			select {
+			case out <- pose:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// EncoderOdometer estimates the robot's pose from wheel encoder counts. It's
+// more accurate than integrating distance and angle, which are rounded on
+// every read. The zero value is ready to use: the first update only sets the
+// reference counts.
+type EncoderOdometer struct {
+	pose        Pose
+	left, right int16
+	started     bool
+}
+
+// Update integrates the movement since the previous counts, as returned by
+// EncoderCounts, and returns the new pose. The counts roll over, so updates
+// must be frequent enough for each wheel to turn less than 32767 counts
+// between them.
+func (o *EncoderOdometer) Update(left, right int16) Pose {
+	if !o.started {
+		o.left, o.right, o.started = left, right, true
+		return o.pose
+	}
+	// int16 subtraction handles the rollover.
+	mmPerCount := math.Pi * wheelDiameter / encoderCountsPerRev
+	dLeft := float64(left-o.left) * mmPerCount
+	dRight := float64(right-o.right) * mmPerCount
+	o.left, o.right = left, right
+
+	o.pose.advance((dLeft+dRight)/2, (dRight-dLeft)/wheelBase)
+	return o.pose
+}
+
+// Pose returns the current pose estimate.
+func (o *EncoderOdometer) Pose() Pose {
+	return o.pose
+}
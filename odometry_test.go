@@ -0,0 +1,188 @@
+package roomba_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestResetOdometry(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	// Leave about 60mm of travel unread.
+	r.DirectDrive(200, 200)
+	time.Sleep(300 * time.Millisecond)
+	r.DirectDrive(0, 0)
+
+	if err := r.ResetOdometry(); err != nil {
+		t.Fatalf("ResetOdometry failed: %s", err)
+	}
+	r.DirectDrive(200, 200)
+	time.Sleep(50 * time.Millisecond)
+	r.DirectDrive(0, 0)
+
+	value, err := r.Sensors(constants.SENSOR_DISTANCE)
+	if err != nil {
+		t.Fatalf("error reading distance: %s", err)
+	}
+	distance := int16(binary.BigEndian.Uint16(value))
+	if distance <= 0 || distance >= 40 {
+		t.Errorf("distance after reset is %d mm, expected about 10 mm", distance)
+	}
+}
+
+func TestEncoderCounts(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_LEFT_ENCODER_COUNTS, []byte{0xff, 0xfe})
+	rt.SetSensorValue(constants.SENSOR_RIGHT_ENCODER_COUNTS, []byte{0x12, 0x34})
+
+	left, right, err := r.EncoderCounts()
+	if err != nil {
+		t.Fatalf("error reading encoder counts: %s", err)
+	}
+	if left != -2 || right != 0x1234 {
+		t.Errorf("encoder counts are %d, %d, expected -2, %d", left, right, 0x1234)
+	}
+	rt.VerifyWritten(r, []byte{149, 2, 43, 44}, t)
+}
+
+func TestStasis(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	for _, c := range []struct {
+		value  byte
+		moving bool
+		err    bool
+	}{{0, false, false}, {1, true, false}, {3, false, true}} {
+		rt.SetSensorValue(constants.SENSOR_STASIS, []byte{c.value})
+		moving, err := r.Stasis()
+		if (err != nil) != c.err {
+			t.Errorf("stasis %d: unexpected error %v", c.value, err)
+		}
+		if moving != c.moving {
+			t.Errorf("stasis %d: moving is %t, expected %t", c.value, moving, c.moving)
+		}
+	}
+}
+
+func TestOdometer(t *testing.T) {
+	var o roomba.Odometer
+	near := func(a, b, tolerance float64) bool { return math.Abs(a-b) <= tolerance }
+
+	p, capped := o.Update(100, 0)
+	if capped || !near(p.X, 100, 1e-9) || !near(p.Y, 0, 1e-9) {
+		t.Errorf("pose after driving straight is %+v (capped %v), expected X 100", p, capped)
+	}
+	// Turn in place by 90° and drive along Y.
+	o.Update(0, 90)
+	p, capped = o.Update(50, 0)
+	if capped || !near(p.X, 100, 1e-9) || !near(p.Y, 50, 1e-9) || !near(p.Heading, math.Pi/2, 1e-9) {
+		t.Errorf("pose after turning and driving is %+v (capped %v), expected (100, 50)", p, capped)
+	}
+	if o.Pose() != p {
+		t.Errorf("Pose() returned %+v, expected %+v", o.Pose(), p)
+	}
+}
+
+func TestOdometerCapped(t *testing.T) {
+	for _, v := range [][2]int16{{32767, 0}, {-32768, 0}, {0, 32767}, {0, -32768}} {
+		var o roomba.Odometer
+		if _, capped := o.Update(v[0], v[1]); !capped {
+			t.Errorf("distance %d and angle %d weren't reported as capped", v[0], v[1])
+		}
+	}
+}
+
+func TestDriveDistanceCapped(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_BUMP_WHEELS_DROPS, []byte{0})
+	rt.SetSensorValue(constants.SENSOR_CLIFF_RIGHT, []byte{0})
+	rt.SetSensorValue(constants.SENSOR_DISTANCE, roomba.Pack([]interface{}{int16(32767)}))
+
+	err := r.DriveDistance(40000, 500)
+	if !errors.Is(err, roomba.ErrOdometryCapped) {
+		t.Fatalf("got error %v, expected ErrOdometryCapped", err)
+	}
+	rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 0}, t)
+}
+
+func TestTrackPose(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	// The first read is the reset. Then 100 mm forward, then a 90° left turn
+	// in place.
+	rt.SetSensorSequence(constants.SENSOR_DISTANCE, [][]byte{{0, 0}, {0, 100}, {0, 0}})
+	rt.SetSensorSequence(constants.SENSOR_ANGLE, [][]byte{{0, 0}, {0, 0}, {0, 90}})
+	ticks := make(chan time.Time)
+	defer roomba.SetTicker(ticks)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	poses, err := r.TrackPose(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("TrackPose failed: %s", err)
+	}
+	expected := []roomba.Pose{{X: 100}, {X: 100, Heading: math.Pi / 2}}
+	for i, want := range expected {
+		ticks <- time.Now()
+		select {
+		case p := <-poses:
+			if math.Abs(p.X-want.X) > 1e-9 || math.Abs(p.Y-want.Y) > 1e-9 || math.Abs(p.Heading-want.Heading) > 1e-9 {
+				t.Errorf("pose %d is %+v, expected %+v", i, p, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no pose after tick %d", i)
+		}
+	}
+	cancel()
+	for range poses {
+	}
+}
+
+func TestEncoderOdometer(t *testing.T) {
+	var o roomba.EncoderOdometer
+	near := func(a, b, tolerance float64) bool { return math.Abs(a-b) <= tolerance }
+
+	// Start close to the rollover.
+	left, right := int16(32000), int16(32000)
+	if p := o.Update(left, right); p != (roomba.Pose{}) {
+		t.Errorf("first update moved the pose: %+v", p)
+	}
+	// 1000 counts forward on both wheels, across the rollover.
+	left += 1000
+	right += 1000
+	p := o.Update(left, right)
+	mm := 1000 * math.Pi * 72 / 508.8
+	if !near(p.X, mm, 0.01) || !near(p.Y, 0, 0.01) || !near(p.Heading, 0, 1e-6) {
+		t.Errorf("pose after driving straight is %+v, expected X %.2f", p, mm)
+	}
+	// 415 counts in opposite directions turn about 90° in place.
+	left -= 415
+	right += 415
+	p = o.Update(left, right)
+	if !near(p.X, mm, 0.01) || !near(p.Y, 0, 0.01) || !near(p.Heading, math.Pi/2, 0.01) {
+		t.Errorf("pose after turning is %+v, expected heading %.3f", p, math.Pi/2)
+	}
+	// Forward again, now along Y.
+	left += 1000
+	right += 1000
+	p = o.Update(left, right)
+	if !near(p.X, mm, 1) || !near(p.Y, mm, 1) {
+		t.Errorf("pose after turning and driving is %+v, expected X and Y %.2f", p, mm)
+	}
+	if o.Pose() != p {
+		t.Errorf("Pose returned %+v, expected %+v", o.Pose(), p)
+	}
+}
@@ -0,0 +1,130 @@
+// Reading the port in the background.
+
+package roomba
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// chunk is the result of a single read from the port.
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// defaultReadChunk is the read size used when MaxReadChunk is 0.
+const defaultReadChunk = 256
+
+// pump reads from r until an error occurs or quit is closed, sending what it
+// reads to chunks. Each read asks for at most size bytes.
+func pump(r io.Reader, size int, chunks chan<- chunk, quit <-chan struct{}) {
+	send := func(c chunk) bool {
+		select {
+		case chunks <- c:
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	for {
+		buf := make([]byte, size)
+		n, err := r.Read(buf)
+		if n > 0 && !send(chunk{data: buf[:n]}) {
+			return
+		}
+		if err != nil {
+			send(chunk{err: err})
+			return
+		}
+	}
+}
+
+// stopPump makes the goroutine reading S exit once its current read returns,
+// even if nobody receives what it read. Pending and later reads return
+// io.EOF until a new goroutine is started by the next read.
+func (roomba *Roomba) stopPump() {
+	roomba.mu.Lock()
+	defer roomba.mu.Unlock()
+	if roomba.pumpQuit != nil {
+		close(roomba.pumpQuit)
+		roomba.pumpQuit = nil
+	}
+}
+
+// readContext works like Read, but returns ctx.Err() if ctx is done before
+// any data arrives. The port is read by a separate goroutine, so giving up
+// doesn't lose data: it's returned by the next read.
+func (roomba *Roomba) readContext(ctx context.Context, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	roomba.readMu.Lock()
+	defer roomba.readMu.Unlock()
+
+	if len(roomba.pending) == 0 {
+		if roomba.chunks == nil {
+			roomba.chunks = make(chan chunk)
+			quit := make(chan struct{})
+			roomba.mu.Lock()
+			roomba.pumpQuit = quit
+			roomba.mu.Unlock()
+			roomba.quit = quit
+			size := roomba.MaxReadChunk
+			if size <= 0 {
+				size = defaultReadChunk
+			}
+			go pump(roomba.S, size, roomba.chunks, quit)
+		}
+		select {
+		case c := <-roomba.chunks:
+			if c.err != nil {
+				// The pump has exited. Start a new one on the next read.
+				roomba.chunks = nil
+				return 0, c.err
+			}
+			roomba.pending = c.data
+			roomba.sendToTaps(c.data)
+		case <-roomba.quit:
+			roomba.chunks = nil
+			return 0, io.EOF
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	n := copy(p, roomba.pending)
+	roomba.pending = roomba.pending[n:]
+	return n, nil
+}
+
+// readFull reads exactly len(p) bytes from the port, returning an error
+// wrapping ErrShortRead if it can't.
+func (roomba *Roomba) readFull(p []byte) error {
+	return roomba.readFullContext(context.Background(), p)
+}
+
+// readFullContext works like readFull, but returns ctx.Err() if ctx is done
+// before all bytes are read.
+func (roomba *Roomba) readFullContext(ctx context.Context, p []byte) error {
+	for n := 0; n < len(p); {
+		m, err := roomba.readContext(ctx, p[n:])
+		n += m
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("%w: read %d of %d bytes: %v", ErrShortRead, n, len(p), err)
+		}
+	}
+	return nil
+}
+
+// resetReader discards buffered data after S was replaced.
+func (roomba *Roomba) resetReader() {
+	roomba.readMu.Lock()
+	defer roomba.readMu.Unlock()
+	roomba.stopPump()
+	roomba.chunks = nil
+	roomba.pending = nil
+}
@@ -0,0 +1,37 @@
+package roomba_test
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+func TestReopenStopsReader(t *testing.T) {
+	defer roomba.SetOpenPort(func(name string, baud int) (io.ReadWriteCloser, error) {
+		return newSilentPort(), nil
+	})()
+	r := &roomba.Roomba{PortName: "/dev/ttyUSB0"}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if err := r.Open(57600); err != nil {
+			t.Fatalf("Open failed: %s", err)
+		}
+		// Start the goroutine reading the port.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		r.SensorsContext(ctx, constants.SENSOR_OI_MODE)
+		cancel()
+		r.Close()
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left running after closing the port, %d before", n, before)
+	}
+}
@@ -0,0 +1,89 @@
+// Streams that survive errors.
+
+package roomba
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// streamRestartDelay is the time between a stream failing and
+// StreamResilient restarting it.
+const streamRestartDelay = 100 * time.Millisecond
+
+// StreamResilient streams the given packets like Stream, but restarts the
+// stream whenever a frame can't be read or parsed, until ctx is done. Before
+// restarting, the stream is paused and the data already sent is discarded, so
+// the new stream starts at a frame boundary. If the port was closed and
+// it's a serial port, it's opened again. Frames are lost while the stream
+// restarts. The returned channel is closed once ctx is done, or right away if
+// the packets can't be streamed.
+func (roomba *Roomba) StreamResilient(ctx context.Context, packetIds []constants.SensorCode) <-chan [][]byte {
+	out := make(chan [][]byte)
+	data, err := (&StreamRequest{packetIds: packetIds}).Build()
+	if err != nil {
+		log.Printf("can't stream packets %v: %v", packetIds, err)
+		close(out)
+		return out
+	}
+	go func() {
+		defer close(out)
+		for {
+			err := roomba.streamUntilError(ctx, packetIds, data, out)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("stream failed, restarting: %v", err)
+			roomba.recoverStream(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(streamRestartDelay):
+			}
+		}
+	}()
+	return out
+}
+
+// streamUntilError starts a stream by sending data, the SensorStream command
+// data for packetIds, and forwards its frames to out until ctx is done or the
+// stream fails.
+func (roomba *Roomba) streamUntilError(ctx context.Context, packetIds []constants.SensorCode, data []byte, out chan<- [][]byte) error {
+	if err := roomba.Write(constants.SensorStream, data); err != nil {
+		return err
+	}
+	frames := make(chan [][]byte)
+	s := roomba.newStream()
+	errc := make(chan error, 1)
+	go func() { errc <- roomba.readStream(packetIds, frames, s) }()
+
+	for frame := range frames {
+		select {
+		case out <- frame:
+		case <-ctx.Done():
+			s.signalStop()
+		}
+	}
+	return <-errc
+}
+
+// recoverStream brings the port back to a state where a stream can be
+// started after err.
+func (roomba *Roomba) recoverStream(err error) {
+	if errors.Is(err, io.EOF) && roomba.PortName != "" {
+		if c, ok := roomba.S.(io.Closer); ok {
+			c.Close()
+		}
+		if err := roomba.Open(roomba.baud); err != nil {
+			log.Printf("failed reopening port: %v", err)
+		}
+		return
+	}
+	roomba.Write(constants.PauseResumeStream, []byte{0})
+	roomba.drain(streamDrainPeriod)
+}
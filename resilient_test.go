@@ -0,0 +1,40 @@
+package roomba_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestStreamResilient(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	// The first frame has a bad checksum, the ones after it are built from
+	// the mock virtual wall value 5.
+	bad := singlePacketFrame(constants.SENSOR_VIRTUAL_WALL, 1)
+	bad[len(bad)-1]++
+	rt.TestSimulator().LoadReplay([][]byte{bad})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := r.StreamResilient(ctx, []constants.SensorCode{constants.SENSOR_VIRTUAL_WALL})
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		select {
+		case frame := <-out:
+			if len(frame) != 1 || frame[0][0] != 5 {
+				t.Errorf("unexpected frame %d: %v", i, frame)
+			}
+		case <-timeout:
+			t.Fatalf("stream didn't recover, got %d frames", i)
+		}
+	}
+	cancel()
+	for range out {
+	}
+	// Started, paused after the error and started again.
+	rt.VerifyWritten(r, []byte{148, 1, 13, 150, 0, 148, 1, 13}, t)
+}
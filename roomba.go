@@ -4,10 +4,145 @@ package roomba
 
 import (
 	"io"
+	"sync"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// Model identifies the family of robot on the other end of the port. Some
+// commands only exist on the original Create, and Roombas store fewer songs.
+type Model int
+
+const (
+	// ModelUnknown doesn't restrict any commands.
+	ModelUnknown Model = iota
+	// ModelRoomba500 is a 500 or 600-series Roomba.
+	ModelRoomba500
+	// ModelCreate is an original iRobot Create.
+	ModelCreate
+	// ModelCreate2 is an iRobot Create 2, which has the Open Interface of
+	// 600-series Roombas rather than that of the original Create.
+	ModelCreate2
 )
 
 type Roomba struct {
 	PortName     string
 	S            io.ReadWriter
 	StreamPaused chan bool
+
+	// ReadOnly makes all writes fail with ErrReadOnly, for monitoring a robot
+	// controlled by someone else, e.g. by reading the stream they requested
+	// with ReadStream.
+	ReadOnly bool
+
+	// Model declares the robot model. Commands that the model doesn't
+	// support return an error instead of being silently ignored by the robot.
+	Model Model
+	// ForceUnsupported sends commands even when Model doesn't support them.
+	ForceUnsupported bool
+
+	// InterCommandDelay is the minimum time between the starts of two
+	// commands. Some serial adapters drop bytes of commands sent back to back.
+	InterCommandDelay time.Duration
+
+	// MaxReadChunk is the largest number of bytes asked from S in a single
+	// read, 256 if it's 0. Some adapters misbehave when asked for more than
+	// they return per read, e.g. 64 bytes. Callers' reads of any size are
+	// still served in full.
+	MaxReadChunk int
+
+	// DrainBefore makes Sensors and QueryList discard the data waiting on the
+	// port before sending their request, e.g. bytes some firmwares send
+	// unsolicited after a mode change, which would be read as the reply. It
+	// makes each read at least drainBeforeQuiet (10 ms) slower.
+	DrainBefore bool
+
+	// AutoStart makes the first command check the OI mode and, if the robot
+	// doesn't answer or is in Off mode, send Start. The command for
+	// AutoStartMode is then sent unless the OI is already in that mode, e.g.
+	// Safe when the robot was left in Passive mode. Modes other than Safe and
+	// Full only start the OI.
+	AutoStart     bool
+	AutoStartMode OIMode
+
+	// StartBeforeCleaning makes Clean, Spot and SeekDock send Start first
+	// unless it was already sent. Unlike AutoStart, it doesn't query the
+	// robot.
+	StartBeforeCleaning bool
+
+	// StrictMode makes Drive, DirectDrive, DrivePWM and Motors return an
+	// error wrapping ErrInvalidMode while the OI is in Passive or Off mode,
+	// where the robot silently ignores them. The mode is tracked from the
+	// mode commands written, so nothing is checked before the first one.
+	StrictMode bool
+
+	// ClampDriveInputs makes Drive and DirectDrive clamp velocities to
+	// -500 – 500 mm/s and radii to -2000 – 2000 mm instead of returning an
+	// error, e.g. for a joystick that overshoots. The special radii are kept.
+	ClampDriveInputs bool
+
+	// CoalesceDrive holds back Drive and DriveDirect commands until Flush is
+	// called or another command is written, and then only sends the latest.
+	// Commands stopping both wheels, such as Stop, are sent right away and
+	// drop the held back one. Control loops that update the drive often can
+	// call Flush once per tick to send less traffic.
+	CoalesceDrive bool
+
+	// RecordHistory makes the commands written to the port available from
+	// CommandHistory and LastCommand. HistorySize is the number of commands
+	// kept, 32 if it's 0. Commands aren't copied when it's not set.
+	RecordHistory bool
+	HistorySize   int
+
+	baud uint // The baud rate S was opened at, if it's a serial port.
+
+	writeMu   sync.Mutex // Serializes writes of whole commands.
+	lastWrite time.Time  // When the last command was written.
+	txMu      sync.Mutex // Serializes commands with the reads of their replies.
+	// Whether a cancelled read may have left its reply on the port. Guarded
+	// by txMu.
+	staleReply bool
+
+	ledMu sync.Mutex // Serializes LED changes and guards leds.
+	leds  ledState   // The LEDs set by the last LEDs command.
+
+	readMu  sync.Mutex      // Guards the fields below.
+	chunks  chan chunk      // Receives data from the goroutine reading S.
+	quit    <-chan struct{} // Closed to make the goroutine reading S exit.
+	pending []byte          // Data received but not yet returned by Read.
+
+	mu     sync.Mutex    // Guards the fields below.
+	stream *activeStream // The stream being read, if any.
+	// The error that ended the last stream, see StreamErr.
+	streamErr error
+	started   bool          // Whether Start was sent or checked by AutoStart.
+	drive     *command      // The drive command held back by CoalesceDrive.
+	history   commandRing   // The last commands written, if RecordHistory is set.
+	stopped   bool          // Whether EmergencyStop was called since Safe or Full.
+	taps      []chan []byte // Receive copies of the data read, see Tap.
+	mode      OIMode        // The mode set by the last mode command.
+	modeSet   bool          // Whether a mode command was written.
+	// The quit channel of the goroutine reading S, closed by stopPump.
+	pumpQuit chan struct{}
+}
+
+// command is an opcode with its data bytes.
+type command struct {
+	opcode constants.OpCode
+	data   []byte
+}
+
+// activeStream tracks the goroutine reading a sensor stream.
+type activeStream struct {
+	stop     chan struct{} // Closed to make the reader exit.
+	stopOnce sync.Once
+	done     chan struct{} // Closed when the reader has exited.
+	// Held while a frame is being read or while a one-off read has the
+	// stream paused. Kept by the reader once it exits.
+	frames chan struct{}
+}
+
+func (s *activeStream) signalStop() {
+	s.stopOnce.Do(func() { close(s.stop) })
 }
@@ -0,0 +1,571 @@
+// Decoders for sensor packets.
+
+package roomba
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// OIMode is the Open Interface mode reported by SENSOR_OI_MODE.
+type OIMode byte
+
+const (
+	OIModeOff OIMode = iota
+	OIModePassive
+	OIModeSafe
+	OIModeFull
+)
+
+func (m OIMode) String() string {
+	switch m {
+	case OIModeOff:
+		return "Off"
+	case OIModePassive:
+		return "Passive"
+	case OIModeSafe:
+		return "Safe"
+	case OIModeFull:
+		return "Full"
+	}
+	return fmt.Sprintf("OIMode(%d)", byte(m))
+}
+
+// ChargingState is the charging state reported by SENSOR_CHARGING.
+type ChargingState byte
+
+const (
+	NotCharging ChargingState = iota
+	ReconditioningCharging
+	FullCharging
+	TrickleCharging
+	Waiting
+	ChargingFault
+)
+
+func (c ChargingState) String() string {
+	switch c {
+	case NotCharging:
+		return "Not charging"
+	case ReconditioningCharging:
+		return "Reconditioning charging"
+	case FullCharging:
+		return "Full charging"
+	case TrickleCharging:
+		return "Trickle charging"
+	case Waiting:
+		return "Waiting"
+	case ChargingFault:
+		return "Charging fault"
+	}
+	return fmt.Sprintf("ChargingState(%d)", byte(c))
+}
+
+// BumpsWheelDrops is the decoded SENSOR_BUMP_WHEELS_DROPS packet.
+type BumpsWheelDrops struct {
+	BumpRight       bool
+	BumpLeft        bool
+	WheelDropRight  bool
+	WheelDropLeft   bool
+	WheelDropCaster bool // Older models only.
+}
+
+func decodeBumpsWheelDrops(b byte) BumpsWheelDrops {
+	return BumpsWheelDrops{
+		BumpRight:       b&1 != 0,
+		BumpLeft:        b&2 != 0,
+		WheelDropRight:  b&4 != 0,
+		WheelDropLeft:   b&8 != 0,
+		WheelDropCaster: b&16 != 0,
+	}
+}
+
+// Buttons is the decoded SENSOR_BUTTONS packet. On Create, the Play button
+// is reported as Clean and the Advance button as Dock.
+type Buttons struct {
+	Clean    bool
+	Spot     bool
+	Dock     bool
+	Minute   bool
+	Hour     bool
+	Day      bool
+	Schedule bool
+	Clock    bool
+}
+
+func decodeButtons(b byte) Buttons {
+	return Buttons{
+		Clean:    b&1 != 0,
+		Spot:     b&2 != 0,
+		Dock:     b&4 != 0,
+		Minute:   b&8 != 0,
+		Hour:     b&16 != 0,
+		Day:      b&32 != 0,
+		Schedule: b&64 != 0,
+		Clock:    b&128 != 0,
+	}
+}
+
+// ChargingSources is the decoded SENSOR_CHARGING_SOURCE packet.
+type ChargingSources struct {
+	InternalCharger bool
+	HomeBase        bool
+}
+
+func decodeChargingSources(b byte) ChargingSources {
+	return ChargingSources{
+		InternalCharger: b&1 != 0,
+		HomeBase:        b&2 != 0,
+	}
+}
+
+// SensorSnapshot holds the values of all sensors in group packet 6.
+type SensorSnapshot struct {
+	BumpsWheelDrops BumpsWheelDrops
+	Wall            bool
+	CliffLeft       bool
+	CliffFrontLeft  bool
+	CliffFrontRight bool
+	CliffRight      bool
+	VirtualWall     bool
+	// Overcurrent bits: 0 side brush, 2 main brush, 3 right wheel,
+	// 4 left wheel.
+	WheelOvercurrents byte
+	IROmni            byte
+	Buttons           Buttons
+	Distance          int16 // mm since last read.
+	Angle             int16 // Degrees since last read.
+	ChargingState     ChargingState
+	Voltage           uint16 // mV
+	Current           int16  // mA
+	Temperature       int8   // °C
+	BatteryCharge     uint16 // mAh
+	BatteryCapacity   uint16 // mAh
+
+	WallSignal            uint16
+	CliffLeftSignal       uint16
+	CliffFrontLeftSignal  uint16
+	CliffFrontRightSignal uint16
+	CliffRightSignal      uint16
+	DigitalInputs         byte
+	AnalogInput           uint16
+	ChargingSources       ChargingSources
+
+	OIMode                 OIMode
+	SongNumber             byte
+	SongPlaying            bool
+	NumStreamPackets       byte
+	RequestedVelocity      int16 // mm/s
+	RequestedRadius        int16 // mm
+	RequestedRightVelocity int16 // mm/s
+	RequestedLeftVelocity  int16 // mm/s
+}
+
+// AllSensorCodes returns the ids of all sensor packets with a known length,
+// in ascending order. Group packets are included, since they can be read like
+// any other packet.
+func AllSensorCodes() []constants.SensorCode {
+	codes := make([]constants.SensorCode, 0, len(constants.SENSOR_PACKET_LENGTH))
+	for code := range constants.SENSOR_PACKET_LENGTH {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
+
+// IsValidSensorCode reports whether c is a sensor packet id with a known
+// length, including group packets.
+func IsValidSensorCode(c constants.SensorCode) bool {
+	_, ok := constants.SENSOR_PACKET_LENGTH[c]
+	return ok
+}
+
+// PacketsTotalLength returns the total length of the values of the given
+// packets, e.g. the reply to a QueryList. It returns a *PacketError wrapping
+// ErrUnknownPacket for the first id with an unknown length.
+func PacketsTotalLength(ids []constants.SensorCode) (int, error) {
+	total := 0
+	for _, id := range ids {
+		length, ok := constants.SENSOR_PACKET_LENGTH[id]
+		if !ok {
+			return 0, &PacketError{id, ErrUnknownPacket}
+		}
+		total += int(length)
+	}
+	return total, nil
+}
+
+// ExpectedReplyLength returns the number of bytes the robot replies with to
+// the command with the given opcode and data bytes: the packet length for
+// Sensors, the total length of the packets for QueryList and 0 for commands
+// without a reply. SensorStream is an error, since its replies don't end.
+func ExpectedReplyLength(opcode constants.OpCode, data []byte) (int, error) {
+	switch opcode {
+	case constants.Sensors:
+		if len(data) != 1 {
+			return 0, fmt.Errorf("invalid Sensors data: %v", data)
+		}
+		return PacketsTotalLength([]constants.SensorCode{constants.SensorCode(data[0])})
+	case constants.QueryList:
+		if len(data) == 0 || len(data) != 1+int(data[0]) {
+			return 0, fmt.Errorf("invalid QueryList data: %v", data)
+		}
+		ids := make([]constants.SensorCode, len(data)-1)
+		for i, id := range data[1:] {
+			ids[i] = constants.SensorCode(id)
+		}
+		return PacketsTotalLength(ids)
+	case constants.SensorStream:
+		return 0, fmt.Errorf("%v replies with a stream", opcode)
+	}
+	return 0, nil
+}
+
+// decodeFlag decodes a packet of a single bit, e.g. SENSOR_WALL. The OI
+// sends 0 or 1, the other bits are reserved, so only the low bit is used.
+func decodeFlag(b byte) bool {
+	return b&1 != 0
+}
+
+func decodeUint16(b []byte) uint16 {
+	return binary.BigEndian.Uint16(b)
+}
+
+func decodeInt16(b []byte) int16 {
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+// splitGroup splits the data of a group packet into the values of its
+// packets.
+func splitGroup(group constants.SensorCode, data []byte) (map[constants.SensorCode][]byte, error) {
+	packetIds, ok := constants.SENSOR_GROUP_PACKETS[group]
+	if !ok {
+		return nil, &PacketError{group, ErrUnknownPacket}
+	}
+	values := make(map[constants.SensorCode][]byte, len(packetIds))
+	for _, packetId := range packetIds {
+		packetLength := int(constants.SENSOR_PACKET_LENGTH[packetId])
+		if len(data) < packetLength {
+			return nil, &PacketError{group, ErrShortRead}
+		}
+		values[packetId] = data[:packetLength]
+		data = data[packetLength:]
+	}
+	return values, nil
+}
+
+// FullSensorSnapshot reads all sensors with a single request for group
+// packet 6.
+func (roomba *Roomba) FullSensorSnapshot() (SensorSnapshot, error) {
+	data, err := roomba.Sensors(constants.SENSOR_GROUP_6)
+	if err != nil {
+		return SensorSnapshot{}, err
+	}
+	v, err := splitGroup(constants.SENSOR_GROUP_6, data)
+	if err != nil {
+		return SensorSnapshot{}, err
+	}
+	return SensorSnapshot{
+		BumpsWheelDrops:   decodeBumpsWheelDrops(v[constants.SENSOR_BUMP_WHEELS_DROPS][0]),
+		Wall:              decodeFlag(v[constants.SENSOR_WALL][0]),
+		CliffLeft:         decodeFlag(v[constants.SENSOR_CLIFF_LEFT][0]),
+		CliffFrontLeft:    decodeFlag(v[constants.SENSOR_CLIFF_FRONT_LEFT][0]),
+		CliffFrontRight:   decodeFlag(v[constants.SENSOR_CLIFF_FRONT_RIGHT][0]),
+		CliffRight:        decodeFlag(v[constants.SENSOR_CLIFF_RIGHT][0]),
+		VirtualWall:       decodeFlag(v[constants.SENSOR_VIRTUAL_WALL][0]),
+		WheelOvercurrents: v[constants.SENSOR_WHEEL_OVERCURRENT][0],
+		IROmni:            v[constants.SENSOR_IR_OMNI][0],
+		Buttons:           decodeButtons(v[constants.SENSOR_BUTTONS][0]),
+		Distance:          decodeInt16(v[constants.SENSOR_DISTANCE]),
+		Angle:             decodeInt16(v[constants.SENSOR_ANGLE]),
+		ChargingState:     ChargingState(v[constants.SENSOR_CHARGING][0]),
+		Voltage:           decodeUint16(v[constants.SENSOR_VOLTAGE]),
+		Current:           decodeInt16(v[constants.SENSOR_CURRENT]),
+		Temperature:       int8(v[constants.SENSOR_TEMPERATURE][0]),
+		BatteryCharge:     decodeUint16(v[constants.SENSOR_BATTERY_CHARGE]),
+		BatteryCapacity:   decodeUint16(v[constants.SENSOR_BATTERY_CAPACITY]),
+
+		WallSignal:            decodeUint16(v[constants.SENSOR_WALL_SIGNAL]),
+		CliffLeftSignal:       decodeUint16(v[constants.SENSOR_CLIFF_LEFT_SIGNAL]),
+		CliffFrontLeftSignal:  decodeUint16(v[constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL]),
+		CliffFrontRightSignal: decodeUint16(v[constants.SENSOR_CLIFF_FRONT_RIGHT_SIGNAL]),
+		CliffRightSignal:      decodeUint16(v[constants.SENSOR_CLIFF_RIGHT_SIGNAL]),
+		DigitalInputs:         v[constants.SENSOR_DIGITAL_INPUTS][0],
+		AnalogInput:           decodeUint16(v[constants.SENSOR_ANALOG_INPUT]),
+		ChargingSources:       decodeChargingSources(v[constants.SENSOR_CHARGING_SOURCE][0]),
+
+		OIMode:                 OIMode(v[constants.SENSOR_OI_MODE][0]),
+		SongNumber:             v[constants.SENSOR_SONG_NUMBER][0],
+		SongPlaying:            decodeFlag(v[constants.SENSOR_SONG_PLAYING][0]),
+		NumStreamPackets:       v[constants.SENSOR_NUM_STREAM_PACKETS][0],
+		RequestedVelocity:      decodeInt16(v[constants.SENSOR_REQUESTED_VELOCITY]),
+		RequestedRadius:        decodeInt16(v[constants.SENSOR_REQUESTED_RADIUS]),
+		RequestedRightVelocity: decodeInt16(v[constants.SENSOR_RIGHT_VELOCITY]),
+		RequestedLeftVelocity:  decodeInt16(v[constants.SENSOR_LEFT_VELOCITY]),
+	}, nil
+}
+
+// Wall reports whether the wall sensor sees a wall. The OI sends 0 or 1 and
+// only the low bit is used, so values with reserved bits set, like the 35
+// mocked by the simulator, are read by that bit alone.
+func (roomba *Roomba) Wall() (bool, error) {
+	v, err := roomba.Sensors(constants.SENSOR_WALL)
+	if err != nil {
+		return false, err
+	}
+	return decodeFlag(v[0]), nil
+}
+
+// Hazards holds the values of the bump, wheel drop, wall, cliff and
+// overcurrent sensors in group packet 1.
+type Hazards struct {
+	BumpsWheelDrops BumpsWheelDrops
+	Wall            bool
+	CliffLeft       bool
+	CliffFrontLeft  bool
+	CliffFrontRight bool
+	CliffRight      bool
+	VirtualWall     bool
+	// Overcurrent bits: 0 side brush, 2 main brush, 3 right wheel,
+	// 4 left wheel.
+	WheelOvercurrents byte
+}
+
+// Hazards reads the sensors a safety monitor needs with a single request for
+// group packet 1.
+func (roomba *Roomba) Hazards() (Hazards, error) {
+	data, err := roomba.Sensors(constants.SENSOR_GROUP_1)
+	if err != nil {
+		return Hazards{}, err
+	}
+	v, err := splitGroup(constants.SENSOR_GROUP_1, data)
+	if err != nil {
+		return Hazards{}, err
+	}
+	return Hazards{
+		BumpsWheelDrops:   decodeBumpsWheelDrops(v[constants.SENSOR_BUMP_WHEELS_DROPS][0]),
+		Wall:              decodeFlag(v[constants.SENSOR_WALL][0]),
+		CliffLeft:         decodeFlag(v[constants.SENSOR_CLIFF_LEFT][0]),
+		CliffFrontLeft:    decodeFlag(v[constants.SENSOR_CLIFF_FRONT_LEFT][0]),
+		CliffFrontRight:   decodeFlag(v[constants.SENSOR_CLIFF_FRONT_RIGHT][0]),
+		CliffRight:        decodeFlag(v[constants.SENSOR_CLIFF_RIGHT][0]),
+		VirtualWall:       decodeFlag(v[constants.SENSOR_VIRTUAL_WALL][0]),
+		WheelOvercurrents: v[constants.SENSOR_WHEEL_OVERCURRENT][0],
+	}, nil
+}
+
+// NavigationState holds the values of the sensors in group packet 2.
+type NavigationState struct {
+	IRChar     byte
+	Buttons    Buttons
+	DistanceMM int16 // Since last read.
+	AngleDeg   int16 // Since last read.
+}
+
+// NavigationState reads the IR character, buttons, distance and angle with a
+// single request for group packet 2. Like reading the distance and angle
+// packets, it resets the robot's distance and angle counters.
+func (roomba *Roomba) NavigationState() (NavigationState, error) {
+	data, err := roomba.Sensors(constants.SENSOR_GROUP_2)
+	if err != nil {
+		return NavigationState{}, err
+	}
+	v, err := splitGroup(constants.SENSOR_GROUP_2, data)
+	if err != nil {
+		return NavigationState{}, err
+	}
+	return NavigationState{
+		IRChar:     v[constants.SENSOR_IR_OMNI][0],
+		Buttons:    decodeButtons(v[constants.SENSOR_BUTTONS][0]),
+		DistanceMM: decodeInt16(v[constants.SENSOR_DISTANCE]),
+		AngleDeg:   decodeInt16(v[constants.SENSOR_ANGLE]),
+	}, nil
+}
+
+// PowerState holds the values of the charging and battery sensors in group
+// packet 3.
+type PowerState struct {
+	ChargingState   ChargingState
+	Voltage         uint16 // mV
+	Current         int16  // mA, negative when discharging.
+	Temperature     int8   // °C
+	BatteryCharge   uint16 // mAh
+	BatteryCapacity uint16 // mAh
+}
+
+// PowerState reads the charging and battery sensors with a single request for
+// group packet 3.
+func (roomba *Roomba) PowerState() (PowerState, error) {
+	data, err := roomba.Sensors(constants.SENSOR_GROUP_3)
+	if err != nil {
+		return PowerState{}, err
+	}
+	v, err := splitGroup(constants.SENSOR_GROUP_3, data)
+	if err != nil {
+		return PowerState{}, err
+	}
+	return PowerState{
+		ChargingState:   ChargingState(v[constants.SENSOR_CHARGING][0]),
+		Voltage:         decodeUint16(v[constants.SENSOR_VOLTAGE]),
+		Current:         decodeInt16(v[constants.SENSOR_CURRENT]),
+		Temperature:     int8(v[constants.SENSOR_TEMPERATURE][0]),
+		BatteryCharge:   decodeUint16(v[constants.SENSOR_BATTERY_CHARGE]),
+		BatteryCapacity: decodeUint16(v[constants.SENSOR_BATTERY_CAPACITY]),
+	}, nil
+}
+
+// SignalStrengths holds the values of the sensors in group packet 4.
+type SignalStrengths struct {
+	WallSignal            uint16
+	CliffLeftSignal       uint16
+	CliffFrontLeftSignal  uint16
+	CliffFrontRightSignal uint16
+	CliffRightSignal      uint16
+	DigitalInputs         byte
+	AnalogInput           uint16
+	ChargingSources       ChargingSources
+}
+
+// SignalStrengths reads the wall and cliff signals, the Create's digital and
+// analog inputs and the available charging sources with a single request for
+// group packet 4, e.g. for wall or cliff following.
+func (roomba *Roomba) SignalStrengths() (SignalStrengths, error) {
+	data, err := roomba.Sensors(constants.SENSOR_GROUP_4)
+	if err != nil {
+		return SignalStrengths{}, err
+	}
+	v, err := splitGroup(constants.SENSOR_GROUP_4, data)
+	if err != nil {
+		return SignalStrengths{}, err
+	}
+	return SignalStrengths{
+		WallSignal:            decodeUint16(v[constants.SENSOR_WALL_SIGNAL]),
+		CliffLeftSignal:       decodeUint16(v[constants.SENSOR_CLIFF_LEFT_SIGNAL]),
+		CliffFrontLeftSignal:  decodeUint16(v[constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL]),
+		CliffFrontRightSignal: decodeUint16(v[constants.SENSOR_CLIFF_FRONT_RIGHT_SIGNAL]),
+		CliffRightSignal:      decodeUint16(v[constants.SENSOR_CLIFF_RIGHT_SIGNAL]),
+		DigitalInputs:         v[constants.SENSOR_DIGITAL_INPUTS][0],
+		AnalogInput:           decodeUint16(v[constants.SENSOR_ANALOG_INPUT]),
+		ChargingSources:       decodeChargingSources(v[constants.SENSOR_CHARGING_SOURCE][0]),
+	}, nil
+}
+
+// DriveState holds the values of the OI and drive state sensors in group
+// packet 5.
+type DriveState struct {
+	OIMode                 OIMode
+	SongNumber             byte
+	SongPlaying            bool
+	NumStreamPackets       byte
+	RequestedVelocity      int16 // mm/s
+	RequestedRadius        int16 // mm
+	RequestedRightVelocity int16 // mm/s
+	RequestedLeftVelocity  int16 // mm/s
+}
+
+// DriveState reads the OI mode, the song state, the number of streamed
+// packets and the last requested drive velocities and radius with a single
+// request for group packet 5.
+func (roomba *Roomba) DriveState() (DriveState, error) {
+	data, err := roomba.Sensors(constants.SENSOR_GROUP_5)
+	if err != nil {
+		return DriveState{}, err
+	}
+	v, err := splitGroup(constants.SENSOR_GROUP_5, data)
+	if err != nil {
+		return DriveState{}, err
+	}
+	return DriveState{
+		OIMode:                 OIMode(v[constants.SENSOR_OI_MODE][0]),
+		SongNumber:             v[constants.SENSOR_SONG_NUMBER][0],
+		SongPlaying:            decodeFlag(v[constants.SENSOR_SONG_PLAYING][0]),
+		NumStreamPackets:       v[constants.SENSOR_NUM_STREAM_PACKETS][0],
+		RequestedVelocity:      decodeInt16(v[constants.SENSOR_REQUESTED_VELOCITY]),
+		RequestedRadius:        decodeInt16(v[constants.SENSOR_REQUESTED_RADIUS]),
+		RequestedRightVelocity: decodeInt16(v[constants.SENSOR_RIGHT_VELOCITY]),
+		RequestedLeftVelocity:  decodeInt16(v[constants.SENSOR_LEFT_VELOCITY]),
+	}, nil
+}
+
+// LightBumpers holds the light bump sensor values of Roomba 600 and Create 2
+// robots.
+type LightBumpers struct {
+	Left        bool
+	FrontLeft   bool
+	CenterLeft  bool
+	CenterRight bool
+	FrontRight  bool
+	Right       bool
+
+	LeftSignal        uint16
+	FrontLeftSignal   uint16
+	CenterLeftSignal  uint16
+	CenterRightSignal uint16
+	FrontRightSignal  uint16
+	RightSignal       uint16
+}
+
+// LightBumpers reads the light bumper detections and signal strengths with a
+// single QueryList request.
+func (roomba *Roomba) LightBumpers() (LightBumpers, error) {
+	v, err := roomba.QueryList([]constants.SensorCode{
+		constants.SENSOR_LIGHT_BUMPER,
+		constants.SENSOR_LIGHT_BUMP_LEFT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_FRONT_LEFT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_CENTER_LEFT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_CENTER_RIGHT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_FRONT_RIGHT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_RIGHT_SIGNAL,
+	})
+	if err != nil {
+		return LightBumpers{}, err
+	}
+	b := v[0][0]
+	return LightBumpers{
+		Left:        b&1 != 0,
+		FrontLeft:   b&2 != 0,
+		CenterLeft:  b&4 != 0,
+		CenterRight: b&8 != 0,
+		FrontRight:  b&16 != 0,
+		Right:       b&32 != 0,
+
+		LeftSignal:        decodeUint16(v[1]),
+		FrontLeftSignal:   decodeUint16(v[2]),
+		CenterLeftSignal:  decodeUint16(v[3]),
+		CenterRightSignal: decodeUint16(v[4]),
+		FrontRightSignal:  decodeUint16(v[5]),
+		RightSignal:       decodeUint16(v[6]),
+	}, nil
+}
+
+// MotorCurrents holds the currents drawn by the motors of Roomba 600 and
+// Create 2 robots, in mA. A current well above normal while driving usually
+// means the motor is stalled.
+type MotorCurrents struct {
+	LeftWheel  int16
+	RightWheel int16
+	MainBrush  int16
+	SideBrush  int16
+}
+
+// MotorCurrents reads the motor currents with a single QueryList request.
+func (roomba *Roomba) MotorCurrents() (MotorCurrents, error) {
+	v, err := roomba.QueryList([]constants.SensorCode{
+		constants.SENSOR_LEFT_MOTOR_CURRENT,
+		constants.SENSOR_RIGHT_MOTOR_CURRENT,
+		constants.SENSOR_MAIN_BRUSH_MOTOR_CURRENT,
+		constants.SENSOR_SIDE_BRUSH_MOTOR_CURRENT,
+	})
+	if err != nil {
+		return MotorCurrents{}, err
+	}
+	return MotorCurrents{
+		LeftWheel:  decodeInt16(v[0]),
+		RightWheel: decodeInt16(v[1]),
+		MainBrush:  decodeInt16(v[2]),
+		SideBrush:  decodeInt16(v[3]),
+	}, nil
+}
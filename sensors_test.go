@@ -0,0 +1,327 @@
+package roomba_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestFullSensorSnapshot(t *testing.T) {
+	payload := roomba.Pack([]interface{}{
+		byte(0x05), byte(1), byte(0), byte(1), byte(0), byte(0), byte(1), byte(0x18), byte(0), byte(0),
+		byte(161), byte(0x04), int16(-150), int16(90),
+		byte(2), uint16(16000), int16(-747), int8(-5), uint16(1000), uint16(1500),
+		uint16(1023), uint16(4095), uint16(525), uint16(0), uint16(12),
+		byte(0x0f), uint16(512), byte(0x02),
+		byte(3), byte(4), byte(1), byte(2), int16(-500), int16(-1), int16(300), int16(-300),
+	})
+	if len(payload) != 52 {
+		t.Fatalf("synthesized payload is %d bytes, expected 52", len(payload))
+	}
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_GROUP_6, payload)
+	s, err := r.FullSensorSnapshot()
+	if err != nil {
+		t.Fatalf("error reading snapshot: %s", err)
+	}
+	expected := roomba.SensorSnapshot{
+		BumpsWheelDrops:   roomba.BumpsWheelDrops{BumpRight: true, WheelDropRight: true},
+		Wall:              true,
+		CliffFrontLeft:    true,
+		VirtualWall:       true,
+		WheelOvercurrents: 0x18,
+		IROmni:            161,
+		Buttons:           roomba.Buttons{Dock: true},
+		Distance:          -150,
+		Angle:             90,
+		ChargingState:     roomba.FullCharging,
+		Voltage:           16000,
+		Current:           -747,
+		Temperature:       -5,
+		BatteryCharge:     1000,
+		BatteryCapacity:   1500,
+
+		WallSignal:            1023,
+		CliffLeftSignal:       4095,
+		CliffFrontLeftSignal:  525,
+		CliffFrontRightSignal: 0,
+		CliffRightSignal:      12,
+		DigitalInputs:         0x0f,
+		AnalogInput:           512,
+		ChargingSources:       roomba.ChargingSources{HomeBase: true},
+
+		OIMode:                 roomba.OIModeFull,
+		SongNumber:             4,
+		SongPlaying:            true,
+		NumStreamPackets:       2,
+		RequestedVelocity:      -500,
+		RequestedRadius:        -1,
+		RequestedRightVelocity: 300,
+		RequestedLeftVelocity:  -300,
+	}
+	if s != expected {
+		t.Errorf("snapshot doesn't match:\n%+v\nexpected\n%+v", s, expected)
+	}
+	rt.VerifyWritten(r, []byte{142, 6}, t)
+}
+
+func TestAllSensorCodes(t *testing.T) {
+	// The packet ids of the OI spec: groups 0-6 and packets 7-58, except the
+	// infrared character left and right packets, 52 and 53, which aren't
+	// defined.
+	var expected []constants.SensorCode
+	for id := 0; id <= 58; id++ {
+		if id != 52 && id != 53 {
+			expected = append(expected, constants.SensorCode(id))
+		}
+	}
+	codes := roomba.AllSensorCodes()
+	if len(codes) != len(expected) {
+		t.Fatalf("got %d codes, expected %d: %v", len(codes), len(expected), codes)
+	}
+	for i, code := range codes {
+		if code != expected[i] {
+			t.Errorf("code %d is %d, expected %d", i, code, expected[i])
+		}
+		if !roomba.IsValidSensorCode(code) {
+			t.Errorf("listed code %d isn't valid", code)
+		}
+	}
+	if roomba.IsValidSensorCode(99) {
+		t.Errorf("code 99 shouldn't be valid")
+	}
+}
+
+func TestPacketsTotalLength(t *testing.T) {
+	total, err := roomba.PacketsTotalLength([]constants.SensorCode{
+		constants.SENSOR_BUMP_WHEELS_DROPS,
+		constants.SENSOR_DISTANCE,
+		constants.SENSOR_GROUP_6,
+	})
+	if err != nil {
+		t.Fatalf("PacketsTotalLength failed: %s", err)
+	}
+	if total != 55 {
+		t.Errorf("total length is %d, expected 55", total)
+	}
+	if total, err := roomba.PacketsTotalLength(nil); total != 0 || err != nil {
+		t.Errorf("total length of no packets is %d, %v, expected 0", total, err)
+	}
+
+	_, err = roomba.PacketsTotalLength([]constants.SensorCode{constants.SENSOR_WALL, 99})
+	var packetErr *roomba.PacketError
+	if !errors.As(err, &packetErr) || packetErr.PacketId != 99 || !errors.Is(err, roomba.ErrUnknownPacket) {
+		t.Errorf("expected ErrUnknownPacket for packet 99, got %v", err)
+	}
+}
+
+func TestWall(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	for value, expected := range map[byte]bool{0: false, 1: true, 35: true, 2: false} {
+		rt.SetSensorValue(constants.SENSOR_WALL, []byte{value})
+		wall, err := r.Wall()
+		if err != nil {
+			t.Fatalf("error reading wall: %s", err)
+		}
+		if wall != expected {
+			t.Errorf("wall sensor %d read as %v, expected %v", value, wall, expected)
+		}
+	}
+}
+
+func TestHazards(t *testing.T) {
+	// Left bump and right wheel drop, front left cliff, virtual wall, main
+	// brush overcurrent and the two unused bytes.
+	payload := []byte{0x06, 0, 0, 1, 0, 0, 1, 0x04, 0, 0}
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_GROUP_1, payload)
+	h, err := r.Hazards()
+	if err != nil {
+		t.Fatalf("error reading hazards: %s", err)
+	}
+	expected := roomba.Hazards{
+		BumpsWheelDrops:   roomba.BumpsWheelDrops{BumpLeft: true, WheelDropRight: true},
+		CliffFrontLeft:    true,
+		VirtualWall:       true,
+		WheelOvercurrents: 0x04,
+	}
+	if h != expected {
+		t.Errorf("hazards don't match:\n%+v\nexpected\n%+v", h, expected)
+	}
+	rt.VerifyWritten(r, []byte{142, 1}, t)
+}
+
+func TestNavigationState(t *testing.T) {
+	payload := roomba.Pack([]interface{}{byte(130), byte(0x05), int16(-250), int16(45)})
+	if len(payload) != 6 {
+		t.Fatalf("synthesized payload is %d bytes, expected 6", len(payload))
+	}
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_GROUP_2, payload)
+	s, err := r.NavigationState()
+	if err != nil {
+		t.Fatalf("error reading navigation state: %s", err)
+	}
+	expected := roomba.NavigationState{
+		IRChar:     130,
+		Buttons:    roomba.Buttons{Clean: true, Dock: true},
+		DistanceMM: -250,
+		AngleDeg:   45,
+	}
+	if s != expected {
+		t.Errorf("navigation state doesn't match:\n%+v\nexpected\n%+v", s, expected)
+	}
+	rt.VerifyWritten(r, []byte{142, 2}, t)
+}
+
+func TestPowerState(t *testing.T) {
+	payload := roomba.Pack([]interface{}{
+		byte(4), uint16(15500), int16(-1200), int8(-3), uint16(2300), uint16(2696),
+	})
+	if len(payload) != 10 {
+		t.Fatalf("synthesized payload is %d bytes, expected 10", len(payload))
+	}
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_GROUP_3, payload)
+	s, err := r.PowerState()
+	if err != nil {
+		t.Fatalf("error reading power state: %s", err)
+	}
+	expected := roomba.PowerState{
+		ChargingState:   roomba.Waiting,
+		Voltage:         15500,
+		Current:         -1200,
+		Temperature:     -3,
+		BatteryCharge:   2300,
+		BatteryCapacity: 2696,
+	}
+	if s != expected {
+		t.Errorf("power state doesn't match:\n%+v\nexpected\n%+v", s, expected)
+	}
+	rt.VerifyWritten(r, []byte{142, 3}, t)
+}
+
+func TestSignalStrengths(t *testing.T) {
+	payload := roomba.Pack([]interface{}{
+		uint16(1023), uint16(4095), uint16(525), uint16(0), uint16(12),
+		byte(0x15), uint16(1001), byte(0x03),
+	})
+	if len(payload) != 14 {
+		t.Fatalf("synthesized payload is %d bytes, expected 14", len(payload))
+	}
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_GROUP_4, payload)
+	s, err := r.SignalStrengths()
+	if err != nil {
+		t.Fatalf("error reading signal strengths: %s", err)
+	}
+	expected := roomba.SignalStrengths{
+		WallSignal:            1023,
+		CliffLeftSignal:       4095,
+		CliffFrontLeftSignal:  525,
+		CliffFrontRightSignal: 0,
+		CliffRightSignal:      12,
+		DigitalInputs:         0x15,
+		AnalogInput:           1001,
+		ChargingSources:       roomba.ChargingSources{InternalCharger: true, HomeBase: true},
+	}
+	if s != expected {
+		t.Errorf("signal strengths don't match:\n%+v\nexpected\n%+v", s, expected)
+	}
+	rt.VerifyWritten(r, []byte{142, 4}, t)
+}
+
+func TestDriveState(t *testing.T) {
+	payload := roomba.Pack([]interface{}{
+		byte(3), byte(7), byte(1), byte(4), int16(-200), int16(-1), int16(150), int16(-150),
+	})
+	if len(payload) != 12 {
+		t.Fatalf("synthesized payload is %d bytes, expected 12", len(payload))
+	}
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_GROUP_5, payload)
+	s, err := r.DriveState()
+	if err != nil {
+		t.Fatalf("error reading drive state: %s", err)
+	}
+	expected := roomba.DriveState{
+		OIMode:                 roomba.OIModeFull,
+		SongNumber:             7,
+		SongPlaying:            true,
+		NumStreamPackets:       4,
+		RequestedVelocity:      -200,
+		RequestedRadius:        -1,
+		RequestedRightVelocity: 150,
+		RequestedLeftVelocity:  -150,
+	}
+	if s != expected {
+		t.Errorf("drive state doesn't match:\n%+v\nexpected\n%+v", s, expected)
+	}
+	rt.VerifyWritten(r, []byte{142, 5}, t)
+}
+
+func TestLightBumpers(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_LIGHT_BUMPER, []byte{0x24})
+	signals := []constants.SensorCode{
+		constants.SENSOR_LIGHT_BUMP_LEFT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_FRONT_LEFT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_CENTER_LEFT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_CENTER_RIGHT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_FRONT_RIGHT_SIGNAL,
+		constants.SENSOR_LIGHT_BUMP_RIGHT_SIGNAL,
+	}
+	for i, code := range signals {
+		rt.SetSensorValue(code, roomba.Pack([]interface{}{uint16(800*i + 95)}))
+	}
+
+	l, err := r.LightBumpers()
+	if err != nil {
+		t.Fatalf("error reading light bumpers: %s", err)
+	}
+	expected := roomba.LightBumpers{
+		CenterLeft: true,
+		Right:      true,
+
+		LeftSignal:        95,
+		FrontLeftSignal:   895,
+		CenterLeftSignal:  1695,
+		CenterRightSignal: 2495,
+		FrontRightSignal:  3295,
+		RightSignal:       4095,
+	}
+	if l != expected {
+		t.Errorf("light bumpers don't match:\n%+v\nexpected\n%+v", l, expected)
+	}
+	rt.VerifyWritten(r, []byte{149, 7, 45, 46, 47, 48, 49, 50, 51}, t)
+}
+
+func TestMotorCurrents(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_LEFT_MOTOR_CURRENT, roomba.Pack([]interface{}{int16(-250)}))
+	rt.SetSensorValue(constants.SENSOR_RIGHT_MOTOR_CURRENT, roomba.Pack([]interface{}{int16(1200)}))
+	rt.SetSensorValue(constants.SENSOR_MAIN_BRUSH_MOTOR_CURRENT, roomba.Pack([]interface{}{int16(-32768)}))
+	rt.SetSensorValue(constants.SENSOR_SIDE_BRUSH_MOTOR_CURRENT, roomba.Pack([]interface{}{int16(32767)}))
+
+	c, err := r.MotorCurrents()
+	if err != nil {
+		t.Fatalf("error reading motor currents: %s", err)
+	}
+	expected := roomba.MotorCurrents{LeftWheel: -250, RightWheel: 1200, MainBrush: -32768, SideBrush: 32767}
+	if c != expected {
+		t.Errorf("motor currents don't match:\n%+v\nexpected\n%+v", c, expected)
+	}
+	rt.VerifyWritten(r, []byte{149, 4, 54, 55, 56, 57}, t)
+}
@@ -0,0 +1,134 @@
+// Generic decoding of sensor packets.
+
+package roomba
+
+import (
+	"fmt"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// SensorType is how the value of a sensor packet is interpreted.
+type SensorType int
+
+const (
+	SensorBool SensorType = iota + 1
+	SensorUint8
+	SensorInt8
+	SensorUint16
+	SensorInt16
+	// SensorBitfield is a byte of flags, e.g. SENSOR_BUMP_WHEELS_DROPS. The
+	// Decode functions of the known bitfields give names to the bits.
+	SensorBitfield
+)
+
+func (t SensorType) String() string {
+	switch t {
+	case SensorBool:
+		return "SensorBool"
+	case SensorUint8:
+		return "SensorUint8"
+	case SensorInt8:
+		return "SensorInt8"
+	case SensorUint16:
+		return "SensorUint16"
+	case SensorInt16:
+		return "SensorInt16"
+	case SensorBitfield:
+		return "SensorBitfield"
+	}
+	return fmt.Sprintf("SensorType(%d)", int(t))
+}
+
+// sensorTypes is the type of each sensor packet, except groups.
+var sensorTypes = map[constants.SensorCode]SensorType{
+	constants.SENSOR_BUMP_WHEELS_DROPS:        SensorBitfield,
+	constants.SENSOR_WALL:                     SensorBool,
+	constants.SENSOR_CLIFF_LEFT:               SensorBool,
+	constants.SENSOR_CLIFF_FRONT_LEFT:         SensorBool,
+	constants.SENSOR_CLIFF_FRONT_RIGHT:        SensorBool,
+	constants.SENSOR_CLIFF_RIGHT:              SensorBool,
+	constants.SENSOR_VIRTUAL_WALL:             SensorBool,
+	constants.SENSOR_WHEEL_OVERCURRENT:        SensorBitfield,
+	15:                                        SensorUint8, // Unused.
+	16:                                        SensorUint8, // Unused.
+	constants.SENSOR_IR_OMNI:                  SensorUint8,
+	constants.SENSOR_BUTTONS:                  SensorBitfield,
+	constants.SENSOR_DISTANCE:                 SensorInt16,
+	constants.SENSOR_ANGLE:                    SensorInt16,
+	constants.SENSOR_CHARGING:                 SensorUint8,
+	constants.SENSOR_VOLTAGE:                  SensorUint16,
+	constants.SENSOR_CURRENT:                  SensorInt16,
+	constants.SENSOR_TEMPERATURE:              SensorInt8,
+	constants.SENSOR_BATTERY_CHARGE:           SensorUint16,
+	constants.SENSOR_BATTERY_CAPACITY:         SensorUint16,
+	constants.SENSOR_WALL_SIGNAL:              SensorUint16,
+	constants.SENSOR_CLIFF_LEFT_SIGNAL:        SensorUint16,
+	constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL:  SensorUint16,
+	constants.SENSOR_CLIFF_FRONT_RIGHT_SIGNAL: SensorUint16,
+	constants.SENSOR_CLIFF_RIGHT_SIGNAL:       SensorUint16,
+	constants.SENSOR_DIGITAL_INPUTS:           SensorBitfield,
+	constants.SENSOR_ANALOG_INPUT:             SensorUint16,
+	constants.SENSOR_CHARGING_SOURCE:          SensorBitfield,
+	constants.SENSOR_OI_MODE:                  SensorUint8,
+	constants.SENSOR_SONG_NUMBER:              SensorUint8,
+	constants.SENSOR_SONG_PLAYING:             SensorBool,
+	constants.SENSOR_NUM_STREAM_PACKETS:       SensorUint8,
+	constants.SENSOR_REQUESTED_VELOCITY:       SensorInt16,
+	constants.SENSOR_REQUESTED_RADIUS:         SensorInt16,
+	constants.SENSOR_RIGHT_VELOCITY:           SensorInt16,
+	constants.SENSOR_LEFT_VELOCITY:            SensorInt16,
+
+	constants.SENSOR_LEFT_ENCODER_COUNTS:  SensorInt16, // Rolls over.
+	constants.SENSOR_RIGHT_ENCODER_COUNTS: SensorInt16,
+	constants.SENSOR_STASIS:               SensorBitfield,
+
+	constants.SENSOR_LIGHT_BUMPER:                   SensorBitfield,
+	constants.SENSOR_LIGHT_BUMP_LEFT_SIGNAL:         SensorUint16,
+	constants.SENSOR_LIGHT_BUMP_FRONT_LEFT_SIGNAL:   SensorUint16,
+	constants.SENSOR_LIGHT_BUMP_CENTER_LEFT_SIGNAL:  SensorUint16,
+	constants.SENSOR_LIGHT_BUMP_CENTER_RIGHT_SIGNAL: SensorUint16,
+	constants.SENSOR_LIGHT_BUMP_FRONT_RIGHT_SIGNAL:  SensorUint16,
+	constants.SENSOR_LIGHT_BUMP_RIGHT_SIGNAL:        SensorUint16,
+
+	constants.SENSOR_LEFT_MOTOR_CURRENT:       SensorInt16,
+	constants.SENSOR_RIGHT_MOTOR_CURRENT:      SensorInt16,
+	constants.SENSOR_MAIN_BRUSH_MOTOR_CURRENT: SensorInt16,
+	constants.SENSOR_SIDE_BRUSH_MOTOR_CURRENT: SensorInt16,
+}
+
+// SensorTypeOf returns the type of a sensor packet. ok is false for unknown
+// packets and groups, which have no type.
+func SensorTypeOf(code constants.SensorCode) (t SensorType, ok bool) {
+	t, ok = sensorTypes[code]
+	return t, ok
+}
+
+// DecodeSensor decodes the value of a sensor packet according to its type,
+// see SensorTypeOf: a bool, taken from the low bit since the others are reserved,
+// a uint8, int8, uint16 or int16, or a byte for bitfields. It returns a
+// *PacketError wrapping ErrUnknownPacket for packets without a type,
+// including groups, and one wrapping ErrShortRead if data doesn't have the
+// length of the packet.
+func DecodeSensor(code constants.SensorCode, data []byte) (interface{}, error) {
+	t, ok := sensorTypes[code]
+	if !ok {
+		return nil, &PacketError{code, ErrUnknownPacket}
+	}
+	if len(data) != int(constants.SENSOR_PACKET_LENGTH[code]) {
+		return nil, &PacketError{code, ErrShortRead}
+	}
+	switch t {
+	case SensorBool:
+		return decodeFlag(data[0]), nil
+	case SensorUint8, SensorBitfield:
+		return data[0], nil
+	case SensorInt8:
+		return int8(data[0]), nil
+	case SensorUint16:
+		return decodeUint16(data), nil
+	case SensorInt16:
+		return decodeInt16(data), nil
+	}
+	return nil, fmt.Errorf("sensor packet %v has invalid type %v", code, t)
+}
@@ -0,0 +1,60 @@
+package roomba_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+func TestDecodeSensor(t *testing.T) {
+	cases := []struct {
+		code     constants.SensorCode
+		data     []byte
+		expected interface{}
+	}{
+		{constants.SENSOR_CURRENT, []byte{0xfd, 0x15}, int16(-747)},
+		{constants.SENSOR_VOLTAGE, []byte{0xea, 0x60}, uint16(60000)},
+		{constants.SENSOR_TEMPERATURE, []byte{0xfb}, int8(-5)},
+		{constants.SENSOR_WALL, []byte{1}, true},
+		{constants.SENSOR_OI_MODE, []byte{3}, uint8(3)},
+		{constants.SENSOR_BUMP_WHEELS_DROPS, []byte{5}, byte(5)},
+		{constants.SENSOR_LEFT_ENCODER_COUNTS, []byte{0xff, 0xfe}, int16(-2)},
+	}
+	for _, c := range cases {
+		v, err := roomba.DecodeSensor(c.code, c.data)
+		if err != nil {
+			t.Errorf("error decoding %v: %s", c.code, err)
+			continue
+		}
+		if v != c.expected {
+			t.Errorf("%v decoded to %v (%T), expected %v (%T)", c.code, v, v, c.expected, c.expected)
+		}
+	}
+
+	if _, err := roomba.DecodeSensor(constants.SENSOR_CURRENT, []byte{1}); !errors.Is(err, roomba.ErrShortRead) {
+		t.Errorf("expected ErrShortRead for a short value, got %v", err)
+	}
+	if _, err := roomba.DecodeSensor(constants.SENSOR_GROUP_6, make([]byte, 52)); !errors.Is(err, roomba.ErrUnknownPacket) {
+		t.Errorf("expected ErrUnknownPacket for a group, got %v", err)
+	}
+}
+
+func TestSensorTypesCoverPackets(t *testing.T) {
+	lengths := map[roomba.SensorType]byte{
+		roomba.SensorBool: 1, roomba.SensorUint8: 1, roomba.SensorInt8: 1, roomba.SensorBitfield: 1,
+		roomba.SensorUint16: 2, roomba.SensorInt16: 2,
+	}
+	for _, code := range roomba.AllSensorCodes() {
+		if _, ok := constants.SENSOR_GROUP_PACKETS[code]; ok {
+			continue
+		}
+		typ, ok := roomba.SensorTypeOf(code)
+		if !ok {
+			t.Errorf("sensor packet %v has no type", code)
+		} else if lengths[typ] != constants.SENSOR_PACKET_LENGTH[code] {
+			t.Errorf("sensor packet %v has type %v but length %d", code, typ, constants.SENSOR_PACKET_LENGTH[code])
+		}
+	}
+}
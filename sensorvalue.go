@@ -0,0 +1,140 @@
+// Sensor values with units.
+
+package roomba
+
+import (
+	"fmt"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// unit is the unit of a sensor packet's value.
+type unit int
+
+const (
+	millivolts unit = iota + 1
+	milliamps
+	celsius
+	millimeters
+	degrees
+	milliampHours
+	millimetersPerSecond
+)
+
+func (u unit) String() string {
+	switch u {
+	case millivolts:
+		return "mV"
+	case milliamps:
+		return "mA"
+	case celsius:
+		return "°C"
+	case millimeters:
+		return "mm"
+	case degrees:
+		return "degrees"
+	case milliampHours:
+		return "mAh"
+	case millimetersPerSecond:
+		return "mm/s"
+	}
+	return "no unit"
+}
+
+// sensorUnits are the units of the packets with a physical quantity.
+var sensorUnits = map[constants.SensorCode]unit{
+	constants.SENSOR_DISTANCE:                 millimeters,
+	constants.SENSOR_ANGLE:                    degrees,
+	constants.SENSOR_VOLTAGE:                  millivolts,
+	constants.SENSOR_CURRENT:                  milliamps,
+	constants.SENSOR_TEMPERATURE:              celsius,
+	constants.SENSOR_BATTERY_CHARGE:           milliampHours,
+	constants.SENSOR_BATTERY_CAPACITY:         milliampHours,
+	constants.SENSOR_REQUESTED_VELOCITY:       millimetersPerSecond,
+	constants.SENSOR_REQUESTED_RADIUS:         millimeters,
+	constants.SENSOR_RIGHT_VELOCITY:           millimetersPerSecond,
+	constants.SENSOR_LEFT_VELOCITY:            millimetersPerSecond,
+	constants.SENSOR_LEFT_MOTOR_CURRENT:       milliamps,
+	constants.SENSOR_RIGHT_MOTOR_CURRENT:      milliamps,
+	constants.SENSOR_MAIN_BRUSH_MOTOR_CURRENT: milliamps,
+	constants.SENSOR_SIDE_BRUSH_MOTOR_CURRENT: milliamps,
+}
+
+// SensorValue is the value of a sensor packet, as returned by Sensors, along
+// with its packet id. The As methods convert it to a quantity in their unit,
+// returning an error if the packet isn't measured in that unit.
+type SensorValue struct {
+	Code constants.SensorCode
+	Raw  []byte
+}
+
+// SensorValue reads a sensor packet like Sensors.
+func (roomba *Roomba) SensorValue(code constants.SensorCode) (SensorValue, error) {
+	raw, err := roomba.Sensors(code)
+	if err != nil {
+		return SensorValue{}, err
+	}
+	return SensorValue{Code: code, Raw: raw}, nil
+}
+
+// SensorReading is a sensor value read along with others by QueryListTyped.
+// It's a SensorValue, so the unit accessors apply.
+type SensorReading = SensorValue
+
+// QueryListTyped works like QueryList, but returns each value along with its
+// packet id, in the order of ids, so callers don't have to match them by
+// position.
+func (roomba *Roomba) QueryListTyped(ids []constants.SensorCode) ([]SensorReading, error) {
+	values, err := roomba.QueryList(ids)
+	if err != nil {
+		return nil, err
+	}
+	readings := make([]SensorReading, len(ids))
+	for i, id := range ids {
+		readings[i] = SensorReading{Code: id, Raw: values[i]}
+	}
+	return readings, nil
+}
+
+// as returns the value if the packet is measured in u.
+func (v SensorValue) as(u unit) (float64, error) {
+	if sensorUnits[v.Code] != u {
+		return 0, fmt.Errorf("sensor packet %v isn't measured in %v", v.Code, u)
+	}
+	value, err := DecodeSensor(v.Code, v.Raw)
+	if err != nil {
+		return 0, err
+	}
+	switch value := value.(type) {
+	case uint8:
+		return float64(value), nil
+	case int8:
+		return float64(value), nil
+	case uint16:
+		return float64(value), nil
+	case int16:
+		return float64(value), nil
+	}
+	return 0, fmt.Errorf("sensor packet %v isn't a number", v.Code)
+}
+
+// AsMillivolts returns the battery voltage.
+func (v SensorValue) AsMillivolts() (float64, error) { return v.as(millivolts) }
+
+// AsMilliamps returns the battery or a motor current.
+func (v SensorValue) AsMilliamps() (float64, error) { return v.as(milliamps) }
+
+// AsCelsius returns the battery temperature.
+func (v SensorValue) AsCelsius() (float64, error) { return v.as(celsius) }
+
+// AsMillimeters returns the distance traveled or the requested radius.
+func (v SensorValue) AsMillimeters() (float64, error) { return v.as(millimeters) }
+
+// AsDegrees returns the angle turned.
+func (v SensorValue) AsDegrees() (float64, error) { return v.as(degrees) }
+
+// AsMilliampHours returns the battery charge or capacity.
+func (v SensorValue) AsMilliampHours() (float64, error) { return v.as(milliampHours) }
+
+// AsMillimetersPerSecond returns a requested velocity.
+func (v SensorValue) AsMillimetersPerSecond() (float64, error) { return v.as(millimetersPerSecond) }
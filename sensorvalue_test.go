@@ -0,0 +1,81 @@
+package roomba_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestSensorValue(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_VOLTAGE, roomba.Pack([]interface{}{uint16(40000)}))
+	rt.SetSensorValue(constants.SENSOR_TEMPERATURE, []byte{0xfb})
+
+	cases := []struct {
+		code     constants.SensorCode
+		as       func(roomba.SensorValue) (float64, error)
+		expected float64
+	}{
+		{constants.SENSOR_VOLTAGE, roomba.SensorValue.AsMillivolts, 40000},
+		{constants.SENSOR_CURRENT, roomba.SensorValue.AsMilliamps, -747},
+		{constants.SENSOR_TEMPERATURE, roomba.SensorValue.AsCelsius, -5},
+		{constants.SENSOR_BATTERY_CHARGE, roomba.SensorValue.AsMilliampHours, 1000},
+	}
+	for _, c := range cases {
+		v, err := r.SensorValue(c.code)
+		if err != nil {
+			t.Fatalf("error reading sensor %d: %s", c.code, err)
+		}
+		if actual, err := c.as(v); err != nil || actual != c.expected {
+			t.Errorf("sensor %d is %v, %v, expected %v", c.code, actual, err, c.expected)
+		}
+	}
+
+	v, err := r.SensorValue(constants.SENSOR_VOLTAGE)
+	if err != nil {
+		t.Fatalf("error reading voltage: %s", err)
+	}
+	if _, err := v.AsCelsius(); err == nil {
+		t.Errorf("voltage was converted to °C")
+	}
+	wall := roomba.SensorValue{Code: constants.SENSOR_WALL, Raw: []byte{1}}
+	if _, err := wall.AsMillimeters(); err == nil {
+		t.Errorf("wall sensor was converted to mm")
+	}
+}
+
+func TestQueryListTyped(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	ids := []constants.SensorCode{
+		constants.SENSOR_CURRENT,
+		constants.SENSOR_OI_MODE,
+		constants.SENSOR_BATTERY_CHARGE,
+		constants.SENSOR_VIRTUAL_WALL,
+	}
+	readings, err := r.QueryListTyped(ids)
+	if err != nil {
+		t.Fatalf("QueryListTyped failed: %s", err)
+	}
+	expected := [][]byte{{0xfd, 0x15}, {2}, {3, 232}, {5}}
+	if len(readings) != len(ids) {
+		t.Fatalf("got %d readings, expected %d", len(readings), len(ids))
+	}
+	for i, reading := range readings {
+		if reading.Code != ids[i] || !bytes.Equal(reading.Raw, expected[i]) {
+			t.Errorf("reading %d is %v %v, expected %v %v", i, reading.Code, reading.Raw, ids[i], expected[i])
+		}
+	}
+	if mA, err := readings[0].AsMilliamps(); err != nil || mA != -747 {
+		t.Errorf("current is %v, %v, expected -747 mA", mA, err)
+	}
+	rt.VerifyWritten(r, []byte{149, 4, 23, 35, 25, 13}, t)
+
+	if _, err := r.QueryListTyped([]constants.SensorCode{99}); err == nil {
+		t.Errorf("QueryListTyped accepted unknown packet 99")
+	}
+}
@@ -4,60 +4,327 @@ package roomba
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/infinities-within/go-roomba/constants"
+	"io"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/tarm/goserial"
 )
 
-// Packs the given data as big endian bytes.
+// Packs the given data as big endian bytes. Signed values are encoded in
+// two's complement, so int16(-500) packs as 0xFE, 0x0C.
 func Pack(data []interface{}) []byte {
+	b, err := pack(data)
+	if err != nil {
+		log.Fatal("failed packing bytes:", err)
+	}
+	return b
+}
+
+// pack works like Pack, but returns an error for values without a fixed size.
+func pack(data []interface{}) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	for _, v := range data {
-		err := binary.Write(buf, binary.BigEndian, v)
-		if err != nil {
-			log.Fatal("failed packing bytes:", err)
+		if err := binary.Write(buf, binary.BigEndian, v); err != nil {
+			return nil, err
 		}
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
+// baudRates are the baud rates supported by the OI, indexed by their code in
+// the Baud command.
+var baudRates = []uint{300, 600, 1200, 2400, 4800, 9600, 14400, 19200, 28800, 38400, 57600, 115200}
+
+// baudSettleTime is how long to wait after the Baud command before sending
+// commands at the new rate.
+const baudSettleTime = 100 * time.Millisecond
+
+// BaudCode returns the code of a baud rate in the Baud command. The OI
+// supports the standard rates from 300 to 115200, codes 0 to 11; other rates
+// are an error.
+func BaudCode(baud uint) (byte, error) {
+	for code, rate := range baudRates {
+		if rate == baud {
+			return byte(code), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid baud rate: %d. Must be one of %v", baud, baudRates)
+}
+
+// openPort opens a serial port. Tests replace it to simulate missing devices.
+var openPort = serial.OpenPort
+
 // Configures and opens the given serial port.
 func (roomba *Roomba) Open(baud uint) error {
-	if baud != 115200 && baud != 19200 {
-		return errors.New(fmt.Sprintf("invalid baud rate: %d. Must be one of 115200, 19200", baud))
+	if _, err := BaudCode(baud); err != nil {
+		return err
 	}
 
 	c := &serial.Config{Name: roomba.PortName, Baud: int(baud)}
-	port, err := serial.OpenPort(c)
+	port, err := openPort(c)
 
 	if err != nil {
 		log.Printf("failed to open serial port: %s", roomba.PortName)
 		return err
 	}
 	roomba.S = port
+	roomba.baud = baud
+	roomba.resetReader()
 	log.Printf("opened serial port: %s", roomba.PortName)
 	return nil
 }
 
+// streamCloseTimeout is how long Close waits for the stream reader to exit
+// before closing the port anyway.
+const streamCloseTimeout = time.Second
+
+// stopActiveStream stops the active stream, if any, and waits up to
+// streamCloseTimeout for its reader to exit. stopped reports whether there
+// was a stream; err is set if its reader didn't exit in time.
+func (roomba *Roomba) stopActiveStream() (stopped bool, err error) {
+	roomba.mu.Lock()
+	s := roomba.stream
+	roomba.mu.Unlock()
+	if s == nil {
+		return false, nil
+	}
+	s.signalStop()
+	select {
+	case <-s.done:
+		return true, nil
+	case <-time.After(streamCloseTimeout):
+		return true, errors.New("stream reader didn't exit")
+	}
+}
+
+// Close stops the active stream, if any, and closes S if it's an io.Closer.
+// The stream reader pauses the stream once the next frame arrives. If none
+// does within streamCloseTimeout, the reader is made to exit anyway, so that
+// it doesn't keep reading the closed port.
+func (roomba *Roomba) Close() error {
+	if _, err := roomba.stopActiveStream(); err != nil {
+		log.Printf("%v, closing the port anyway", err)
+	}
+	roomba.stopPump()
+	if c, ok := roomba.S.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SetBaud changes the baud rate of the OI to baud, one of the rates BaudCode
+// accepts, reopens the port at that rate and checks that the robot answers. If
+// the Roomba doesn't have a PortName, e.g. because S is a network connection,
+// S is kept as is.
+func (roomba *Roomba) SetBaud(baud uint) error {
+	code, err := BaudCode(baud)
+	if err != nil {
+		return err
+	}
+	if err := roomba.Write(constants.Baud, []byte{code}); err != nil {
+		return err
+	}
+	time.Sleep(baudSettleTime)
+	if roomba.PortName != "" {
+		if c, ok := roomba.S.(io.Closer); ok {
+			c.Close()
+		}
+		if err := roomba.Open(baud); err != nil {
+			return err
+		}
+	}
+	if err := roomba.Ping(); err != nil {
+		return fmt.Errorf("baud rate change to %d failed: %w", baud, err)
+	}
+	return nil
+}
+
 // Writes the given opcode byte and a sequence of data bytes to the serial port.
 func (roomba *Roomba) Write(opcode constants.OpCode, p []byte) error {
+	if roomba.ReadOnly {
+		return fmt.Errorf("writing %v: %w", opcode, ErrReadOnly)
+	}
+	if roomba.CoalesceDrive && (opcode == constants.Drive || opcode == constants.DriveDirect) {
+		stop := stopsWheels(opcode, p)
+		roomba.mu.Lock()
+		if stop {
+			// Stopping can't wait for Flush, and replaces the held back drive.
+			roomba.drive = nil
+		} else {
+			roomba.drive = &command{opcode, append([]byte(nil), p...)}
+		}
+		roomba.mu.Unlock()
+		if !stop {
+			return nil
+		}
+		return roomba.write(opcode, p)
+	}
+	if err := roomba.Flush(); err != nil {
+		return err
+	}
+	return roomba.write(opcode, p)
+}
+
+// stopsWheels reports whether the Drive or DriveDirect data stops both wheels.
+func stopsWheels(opcode constants.OpCode, p []byte) bool {
+	if len(p) != 4 {
+		return false
+	}
+	if opcode == constants.Drive {
+		// The radius doesn't matter at zero velocity.
+		return p[0] == 0 && p[1] == 0
+	}
+	return p[0]|p[1]|p[2]|p[3] == 0
+}
+
+// Flush writes the drive command held back by CoalesceDrive, if any.
+func (roomba *Roomba) Flush() error {
+	roomba.mu.Lock()
+	drive := roomba.drive
+	roomba.drive = nil
+	roomba.mu.Unlock()
+	if drive == nil {
+		return nil
+	}
+	return roomba.write(drive.opcode, drive.data)
+}
+
+// write writes a command to the port without holding back drive commands.
+func (roomba *Roomba) write(opcode constants.OpCode, p []byte) error {
+	switch opcode {
+	case constants.Start:
+		roomba.mu.Lock()
+		roomba.started = true
+		roomba.mu.Unlock()
+	case constants.Stop:
+		roomba.mu.Lock()
+		roomba.started = false
+		roomba.mu.Unlock()
+	case constants.Sensors, constants.QueryList:
+		// Checked by the caller before the transaction is started.
+	default:
+		if err := roomba.autoStart(); err != nil {
+			return err
+		}
+	}
+	if mode, ok := modeAfter(opcode); ok {
+		roomba.mu.Lock()
+		roomba.mode, roomba.modeSet = mode, true
+		roomba.mu.Unlock()
+	}
 	log.Printf("Writing opcode: %v, data %v", opcode, p)
+	roomba.writeMu.Lock()
+	defer roomba.writeMu.Unlock()
+	var idle time.Duration
+	if !roomba.lastWrite.IsZero() {
+		idle = time.Since(roomba.lastWrite)
+	}
+	if roomba.InterCommandDelay > 0 && !roomba.lastWrite.IsZero() {
+		time.Sleep(roomba.InterCommandDelay - idle)
+	}
+	roomba.lastWrite = time.Now()
+	if roomba.RecordHistory {
+		roomba.recordCommand(opcode, p, roomba.lastWrite)
+	}
 	n, err := roomba.S.Write([]byte{byte(opcode)})
 	if n != 1 || err != nil {
-		return fmt.Errorf("failed writing opcode %d to serial interface",
-			opcode)
+		return checkAsleepWrite(idle, fmt.Errorf("failed writing opcode %d to serial interface",
+			opcode))
 	}
 	n, err = roomba.S.Write(p)
 	if n != len(p) || err != nil {
-		return fmt.Errorf("failed writing command to serial interface: % d", p)
+		return checkAsleepWrite(idle, fmt.Errorf("failed writing command to serial interface: % d", p))
 	}
 	return nil
 }
 
+// modeAfter returns the OI mode the robot is in after the given command, if
+// the command changes it.
+func modeAfter(opcode constants.OpCode) (OIMode, bool) {
+	switch opcode {
+	case constants.Start, constants.Cover, constants.Spot, constants.Dock, constants.Power, constants.Demo:
+		return OIModePassive, true
+	case constants.Safe, constants.Control:
+		return OIModeSafe, true
+	case constants.Full:
+		return OIModeFull, true
+	case constants.Stop, constants.Reset:
+		return OIModeOff, true
+	}
+	return 0, false
+}
+
+// checkMode returns an error wrapping ErrInvalidMode if StrictMode is set and
+// the actuator command would be ignored in the tracked mode.
+func (roomba *Roomba) checkMode(opcode constants.OpCode) error {
+	if !roomba.StrictMode {
+		return nil
+	}
+	roomba.mu.Lock()
+	mode, modeSet := roomba.mode, roomba.modeSet
+	roomba.mu.Unlock()
+	if modeSet && (mode == OIModeOff || mode == OIModePassive) {
+		return fmt.Errorf("%v in %v mode: %w; call Safe or Full first", opcode, mode, ErrInvalidMode)
+	}
+	return nil
+}
+
+// autoStart makes sure the OI is started before the first command if
+// AutoStart is set.
+func (roomba *Roomba) autoStart() error {
+	roomba.mu.Lock()
+	if !roomba.AutoStart || roomba.started {
+		roomba.mu.Unlock()
+		return nil
+	}
+	roomba.started = true
+	roomba.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	current := OIModeOff
+	if mode, err := roomba.SensorsContext(ctx, constants.SENSOR_OI_MODE); err == nil {
+		current = OIMode(mode[0])
+	}
+	if current == OIModeOff {
+		log.Printf("OI isn't started, sending Start")
+		if err := roomba.Start(); err != nil {
+			return err
+		}
+		current = OIModePassive
+	}
+	if current == roomba.AutoStartMode {
+		return nil
+	}
+	switch roomba.AutoStartMode {
+	case OIModeSafe:
+		log.Printf("OI is in %v mode, switching to Safe", current)
+		return roomba.Safe()
+	case OIModeFull:
+		log.Printf("OI is in %v mode, switching to Full", current)
+		return roomba.Full()
+	}
+	return nil
+}
+
+// Command packs args as big endian bytes and writes them with the given
+// opcode. Args must have fixed sizes, e.g. byte or int16, but not int. It can
+// be used to send commands the library doesn't wrap.
+func (roomba *Roomba) Command(opcode constants.OpCode, args ...interface{}) error {
+	p, err := pack(args)
+	if err != nil {
+		return fmt.Errorf("failed packing arguments of opcode %d: %s", opcode, err)
+	}
+	return roomba.Write(opcode, p)
+}
+
 // Writes a single byte to the serial port.
 func (roomba *Roomba) WriteByte(opcode constants.OpCode) error {
 	return roomba.Write(opcode, []byte{})
@@ -65,5 +332,152 @@ func (roomba *Roomba) WriteByte(opcode constants.OpCode) error {
 
 // Reads bytes from the serial port.
 func (roomba *Roomba) Read(p []byte) (n int, err error) {
-	return roomba.S.Read(p)
+	return roomba.readContext(context.Background(), p)
+}
+
+// ReadN reads exactly n bytes from the port, e.g. the reply to a command
+// written with Write or Command. If they don't all arrive within timeout, it
+// returns the bytes read so far and an error wrapping ErrShortRead.
+func (roomba *Roomba) ReadN(n int, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	buf := make([]byte, n)
+	for read := 0; read < n; {
+		m, err := roomba.readContext(ctx, buf[read:])
+		read += m
+		if err != nil {
+			return buf[:read], fmt.Errorf("%w: read %d of %d bytes: %v", ErrShortRead, read, n, err)
+		}
+	}
+	return buf, nil
+}
+
+// WriteRead writes a command and reads its reply, whose length is given by
+// ExpectedReplyLength, e.g. for tools sending raw commands. Like Sensors, it
+// pauses a running stream while the reply is read. If the reply doesn't
+// arrive within timeout, it returns the bytes read so far and an error
+// wrapping ErrShortRead.
+func (roomba *Roomba) WriteRead(opcode constants.OpCode, data []byte, timeout time.Duration) ([]byte, error) {
+	n, err := ExpectedReplyLength(opcode, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := roomba.autoStart(); err != nil {
+		return nil, err
+	}
+	roomba.txMu.Lock()
+	defer roomba.txMu.Unlock()
+	resume, err := roomba.pauseStreamForRead(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer resume()
+	if err := roomba.Write(opcode, data); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return []byte{}, nil
+	}
+	return roomba.ReadN(n, timeout)
+}
+
+// tapBuffer is the number of reads buffered for each tap.
+const tapBuffer = 64
+
+// Tap returns a channel receiving a copy of all data read from the port, e.g.
+// for logging the protocol alongside the normal control code, and a function
+// that closes the channel. The data is tapped as it's read from S, so it
+// includes bytes discarded by the driver. A tap that isn't read fast enough
+// misses data rather than slowing down the reads.
+func (roomba *Roomba) Tap() (<-chan []byte, func()) {
+	tap := make(chan []byte, tapBuffer)
+	roomba.mu.Lock()
+	roomba.taps = append(roomba.taps, tap)
+	roomba.mu.Unlock()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			roomba.mu.Lock()
+			defer roomba.mu.Unlock()
+			for i, t := range roomba.taps {
+				if t == tap {
+					roomba.taps = append(roomba.taps[:i], roomba.taps[i+1:]...)
+					break
+				}
+			}
+			close(tap)
+		})
+	}
+	return tap, stop
+}
+
+// sendToTaps sends a copy of data to the channels returned by Tap.
+func (roomba *Roomba) sendToTaps(data []byte) {
+	roomba.mu.Lock()
+	defer roomba.mu.Unlock()
+	for _, tap := range roomba.taps {
+		select {
+		case tap <- append([]byte(nil), data...):
+		default:
+			log.Printf("tap is full, dropped %d bytes", len(data))
+		}
+	}
+}
+
+// drainBeforeQuiet is how long DrainBefore waits for data before a read.
+const drainBeforeQuiet = 10 * time.Millisecond
+
+// DiscardInput reads and discards data from the port for d and returns the
+// number of bytes discarded, e.g. to skip the chatter of a mode change. It
+// waits for sensor reads in progress, but shouldn't be used while a stream is
+// running.
+func (roomba *Roomba) DiscardInput(d time.Duration) int {
+	roomba.txMu.Lock()
+	defer roomba.txMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	buf := make([]byte, 256)
+	total := 0
+	for {
+		n, err := roomba.readContext(ctx, buf)
+		total += n
+		if err != nil {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("discarded %d bytes", total)
+	}
+	return total
+}
+
+// staleReplyQuiet is how long drainBefore waits for the rest of a reply whose
+// read was cancelled.
+const staleReplyQuiet = 50 * time.Millisecond
+
+// drainBefore discards the data waiting on the port if DrainBefore is set, or
+// the late reply of a cancelled read. It's called holding txMu.
+func (roomba *Roomba) drainBefore() {
+	switch {
+	case roomba.staleReply:
+		roomba.drain(staleReplyQuiet)
+		roomba.staleReply = false
+	case roomba.DrainBefore:
+		roomba.drain(drainBeforeQuiet)
+	}
+}
+
+// drain discards data read from the port until none arrives for quiet.
+func (roomba *Roomba) drain(quiet time.Duration) {
+	buf := make([]byte, 256)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), quiet)
+		n, err := roomba.readContext(ctx, buf)
+		cancel()
+		if err != nil {
+			return
+		}
+		log.Printf("discarded %d bytes", n)
+	}
 }
@@ -0,0 +1,409 @@
+package roomba_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	"github.com/infinities-within/go-roomba/sim"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestPackSigned(t *testing.T) {
+	if b := roomba.Pack([]interface{}{int16(-500)}); b[0] != 0xFE || b[1] != 0x0C {
+		t.Errorf("int16(-500) packed as % x, expected fe 0c", b)
+	}
+	for v := -32768; v <= 32767; v++ {
+		b := roomba.Pack([]interface{}{int16(v)})
+		if len(b) != 2 {
+			t.Fatalf("int16(%d) packed into %d bytes", v, len(b))
+		}
+		if actual := int16(binary.BigEndian.Uint16(b)); int(actual) != v {
+			t.Fatalf("int16(%d) packed as % x, which decodes as %d", v, b, actual)
+		}
+	}
+}
+
+func TestDriveSignedRoundTrip(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	for _, velocity := range []int16{-500, -200, -1, 0, 1, 500} {
+		for _, radius := range []int16{-2000, -1, 1, 2000} {
+			if err := r.Drive(velocity, radius); err != nil {
+				t.Fatalf("Drive(%d, %d) failed: %s", velocity, radius, err)
+			}
+			values, err := r.QueryList([]constants.SensorCode{
+				constants.SENSOR_REQUESTED_VELOCITY,
+				constants.SENSOR_REQUESTED_RADIUS})
+			if err != nil {
+				t.Fatalf("error reading requested velocity and radius: %s", err)
+			}
+			actualVelocity := int16(binary.BigEndian.Uint16(values[0]))
+			actualRadius := int16(binary.BigEndian.Uint16(values[1]))
+			if actualVelocity != velocity || actualRadius != radius {
+				t.Errorf("Drive(%d, %d) read back as (%d, %d)", velocity, radius,
+					actualVelocity, actualRadius)
+			}
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	r.Drive(-200, 500)
+	if err := r.Command(constants.Drive, int16(-200), int16(500)); err != nil {
+		t.Fatalf("Command failed: %s", err)
+	}
+	if err := r.Command(constants.Drive, -200, 500); err == nil {
+		t.Errorf("Command didn't fail for arguments without a fixed size")
+	}
+	rt.VerifyWritten(r, []byte{137, 255, 56, 1, 244, 137, 255, 56, 1, 244}, t)
+}
+
+func TestInterCommandDelay(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	r.InterCommandDelay = 50 * time.Millisecond
+	start := time.Now()
+	r.Safe()
+	r.Full()
+	r.Safe()
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("three commands took %v, expected at least 100ms", elapsed)
+	}
+	rt.VerifyWritten(r, []byte{131, 132, 131}, t)
+}
+
+func TestBaudCode(t *testing.T) {
+	rates := []uint{300, 600, 1200, 2400, 4800, 9600, 14400, 19200, 28800, 38400, 57600, 115200}
+	for code, rate := range rates {
+		c, err := roomba.BaudCode(rate)
+		if err != nil {
+			t.Errorf("BaudCode(%d) failed: %s", rate, err)
+		}
+		if c != byte(code) {
+			t.Errorf("BaudCode(%d) is %d, expected %d", rate, c, code)
+		}
+	}
+	for _, rate := range []uint{0, 100000, 230400} {
+		if _, err := roomba.BaudCode(rate); err == nil {
+			t.Errorf("BaudCode(%d) succeeded for an unsupported rate", rate)
+		}
+	}
+}
+
+func TestBaud(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	if err := r.Baud(19200); err != nil {
+		t.Fatalf("Baud failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{129, 7}, t)
+	if err := r.Baud(100000); err == nil {
+		t.Errorf("Baud succeeded for an unsupported rate")
+	}
+}
+
+func TestSetBaud(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	if err := r.SetBaud(100000); err == nil {
+		t.Errorf("SetBaud accepted the unsupported rate 100000")
+	}
+	if err := r.SetBaud(115200); err != nil {
+		t.Fatalf("SetBaud failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{129, 11, 142, 35}, t)
+
+	port := newSilentPort()
+	defer port.Close()
+	silent := &roomba.Roomba{S: port}
+	if err := silent.SetBaud(57600); err == nil {
+		t.Errorf("SetBaud succeeded without an answer from the robot")
+	}
+}
+
+func TestCoalesceDrive(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.CoalesceDrive = true
+	defer func() { r.CoalesceDrive = false }()
+
+	r.Drive(100, 0)
+	r.Drive(200, 0)
+	r.Drive(300, 0)
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{137, 1, 44, 0, 0}, t)
+
+	// Other commands send the held back drive first.
+	r.DirectDrive(50, 50)
+	r.Motors(1)
+	rt.VerifyWritten(r, []byte{145, 0, 50, 0, 50, 138, 1}, t)
+}
+
+func TestCoalesceDriveStop(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.CoalesceDrive = true
+	defer func() { r.CoalesceDrive = false }()
+
+	r.Drive(100, 0)
+	r.Flush()
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop failed: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{137, 0, 100, 0, 0, 137, 0, 0, 0, 0}, t)
+	rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 0}, t)
+
+	// Stopping drops the drive held back.
+	r.Drive(200, 0)
+	r.Stop()
+	r.Flush()
+	rt.VerifyWritten(r, []byte{142, 39, 137, 0, 0, 0, 0}, t)
+}
+
+func TestReadN(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	r.Write(constants.Sensors, []byte{byte(constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL)})
+	b, err := r.ReadN(2, time.Second)
+	if err != nil {
+		t.Fatalf("ReadN failed: %s", err)
+	}
+	if b[0] != 2 || b[1] != 25 {
+		t.Errorf("read %v, expected [2 25]", b)
+	}
+
+	// The temperature is a single byte, the second never arrives.
+	r.Write(constants.Sensors, []byte{byte(constants.SENSOR_TEMPERATURE)})
+	b, err = r.ReadN(2, 100*time.Millisecond)
+	if !errors.Is(err, roomba.ErrShortRead) {
+		t.Errorf("expected ErrShortRead, got %v", err)
+	}
+	if len(b) != 1 || b[0] != 25 {
+		t.Errorf("read %v before the timeout, expected [25]", b)
+	}
+}
+
+func TestExpectedReplyLength(t *testing.T) {
+	cases := []struct {
+		opcode   constants.OpCode
+		data     []byte
+		expected int
+	}{
+		{constants.Sensors, []byte{byte(constants.SENSOR_VOLTAGE)}, 2},
+		{constants.Sensors, []byte{byte(constants.SENSOR_GROUP_6)}, 52},
+		{constants.QueryList, []byte{3, 22, 35, 7}, 4},
+		{constants.Drive, []byte{0, 0, 0, 0}, 0},
+	}
+	for _, c := range cases {
+		n, err := roomba.ExpectedReplyLength(c.opcode, c.data)
+		if err != nil || n != c.expected {
+			t.Errorf("ExpectedReplyLength(%v, %v) = %d, %v, expected %d", c.opcode, c.data, n, err, c.expected)
+		}
+	}
+	for _, c := range []struct {
+		opcode constants.OpCode
+		data   []byte
+	}{
+		{constants.Sensors, []byte{99}},
+		{constants.Sensors, []byte{}},
+		{constants.QueryList, []byte{3, 22, 35}},
+		{constants.SensorStream, []byte{1, 7}},
+	} {
+		if _, err := roomba.ExpectedReplyLength(c.opcode, c.data); err == nil {
+			t.Errorf("ExpectedReplyLength(%v, %v) succeeded", c.opcode, c.data)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	b, err := r.WriteRead(constants.QueryList, []byte{2, 24, 35}, time.Second)
+	if err != nil || !bytes.Equal(b, []byte{25, 2}) {
+		t.Errorf("QueryList replied %v, %v, expected [25 2]", b, err)
+	}
+	b, err = r.WriteRead(constants.Sensors, []byte{byte(constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL)}, time.Second)
+	if err != nil || !bytes.Equal(b, []byte{2, 25}) {
+		t.Errorf("Sensors replied %v, %v, expected [2 25]", b, err)
+	}
+	if b, err := r.WriteRead(constants.Start, nil, time.Second); err != nil || len(b) != 0 {
+		t.Errorf("Start replied %v, %v, expected nothing", b, err)
+	}
+	rt.VerifyWritten(r, []byte{149, 2, 24, 35, 142, 29, 128}, t)
+}
+
+// chunkedPort returns at most max bytes per read, like some serial adapters,
+// and records the largest read asked for.
+type chunkedPort struct {
+	io.ReadWriter
+	max       int
+	largest   int
+	largestMu sync.Mutex
+}
+
+func (p *chunkedPort) Read(b []byte) (int, error) {
+	p.largestMu.Lock()
+	if len(b) > p.largest {
+		p.largest = len(b)
+	}
+	p.largestMu.Unlock()
+	if len(b) > p.max {
+		b = b[:p.max]
+	}
+	return p.ReadWriter.Read(b)
+}
+
+func TestMaxReadChunk(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+	port := &chunkedPort{ReadWriter: rw, max: 8}
+	r := &roomba.Roomba{S: port, MaxReadChunk: 8}
+
+	data, err := r.Sensors(constants.SENSOR_GROUP_6)
+	if err != nil {
+		t.Fatalf("error reading group 6: %s", err)
+	}
+	if len(data) != 52 {
+		t.Errorf("read %d bytes, expected 52", len(data))
+	}
+	port.largestMu.Lock()
+	defer port.largestMu.Unlock()
+	if port.largest > 8 {
+		t.Errorf("asked the port for %d bytes, more than MaxReadChunk", port.largest)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+	r := &roomba.Roomba{S: rw, StreamPaused: make(chan bool, 1), ReadOnly: true}
+
+	if err := r.Drive(100, 0); !errors.Is(err, roomba.ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Drive, got %v", err)
+	}
+	if err := r.WriteByte(constants.Start); !errors.Is(err, roomba.ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from WriteByte, got %v", err)
+	}
+
+	// Another controller on the line requests a stream.
+	rw.Write([]byte{148, 1, byte(constants.SENSOR_VIRTUAL_WALL)})
+	out := make(chan [][]byte)
+	go r.ReadStream([]constants.SensorCode{constants.SENSOR_VIRTUAL_WALL}, out)
+	select {
+	case frame := <-out:
+		if frame[0][0] != 5 {
+			t.Errorf("unexpected frame: %v", frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no frame read")
+	}
+	r.StopStream()
+	for range out {
+	}
+	if read := s.ReadLog(100); len(read) != 3 {
+		t.Errorf("the read-only Roomba wrote to the port: %v", read)
+	}
+}
+
+func TestTap(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	tap, stop := r.Tap()
+	if _, err := r.Sensors(constants.SENSOR_TEMPERATURE); err != nil {
+		t.Fatalf("error reading temperature: %s", err)
+	}
+	if _, err := r.Sensors(constants.SENSOR_BATTERY_CHARGE); err != nil {
+		t.Fatalf("error reading battery charge: %s", err)
+	}
+	stop()
+
+	var tapped []byte
+	for data := range tap {
+		tapped = append(tapped, data...)
+	}
+	expected := []byte{25, 3, 232}
+	if string(tapped) != string(expected) {
+		t.Errorf("tapped % d, expected % d", tapped, expected)
+	}
+	stop()
+}
+
+// simPort is a simulator connection that stops the simulator when closed.
+type simPort struct {
+	io.ReadWriter
+	sim *sim.RoombaSimulator
+}
+
+func (p *simPort) Close() error {
+	p.sim.Stop()
+	return nil
+}
+
+func TestCloseDuringStream(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s, rw := sim.MakeRoombaSim()
+	r := &roomba.Roomba{S: &simPort{rw, s}, StreamPaused: make(chan bool, 1)}
+
+	out, err := r.Stream([]constants.SensorCode{constants.SENSOR_VIRTUAL_WALL})
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	<-out
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	for range out {
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running after Close, %d before the stream",
+				runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiscardInput(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.TestSimulator().Inject([]byte{1, 2, 3})
+	if n := r.DiscardInput(50 * time.Millisecond); n != 3 {
+		t.Errorf("discarded %d bytes, expected 3", n)
+	}
+	rt.VerifySensorValue(r, constants.SENSOR_OI_MODE, []byte{2}, t)
+}
+
+func TestDrainBefore(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.DrainBefore = true
+	defer func() { r.DrainBefore = false }()
+	rt.TestSimulator().Inject([]byte{7})
+	rt.VerifySensorValue(r, constants.SENSOR_OI_MODE, []byte{2}, t)
+	rt.TestSimulator().Inject([]byte{7, 7})
+	v, err := r.QueryList([]constants.SensorCode{constants.SENSOR_TEMPERATURE, constants.SENSOR_OI_MODE})
+	if err != nil || !bytes.Equal(v[0], []byte{25}) || !bytes.Equal(v[1], []byte{2}) {
+		t.Errorf("QueryList returned %v, %v after chatter, expected [[25] [2]]", v, err)
+	}
+}
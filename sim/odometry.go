@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+// Distance between the drive wheels of a Roomba in millimeters.
+const wheelBase = 235.0
+
+// odometry is a simple kinematic model of the drive wheels. It backs the
+// distance and angle sensors, which report travel since they were last read.
+type odometry struct {
+	right, left float64   // Wheel velocities in mm/s.
+	updated     time.Time // When distance and angle were last integrated.
+
+	distance float64 // Millimeters traveled since the last distance read.
+	angle    float64 // Degrees turned since the last angle read.
+}
+
+// update integrates the wheel velocities up to now.
+func (o *odometry) update() {
+	now := time.Now()
+	if !o.updated.IsZero() {
+		dt := now.Sub(o.updated).Seconds()
+		o.distance += (o.right + o.left) / 2 * dt
+		o.angle += (o.right - o.left) / wheelBase * dt * 180 / math.Pi
+	}
+	o.updated = now
+}
+
+// drive sets the wheel velocities as requested by a Drive command.
+func (o *odometry) drive(velocity, radius int16) {
+	v, r := float64(velocity), float64(radius)
+	switch radius {
+	case 32767, -32768:
+		o.setWheels(v, v)
+	case -1:
+		o.setWheels(-v, v)
+	case 1:
+		o.setWheels(v, -v)
+	default:
+		if radius == 0 {
+			o.setWheels(v, v)
+			return
+		}
+		o.setWheels(v*(r+wheelBase/2)/r, v*(r-wheelBase/2)/r)
+	}
+}
+
+// setWheels sets the wheel velocities as requested by a DriveDirect command.
+func (o *odometry) setWheels(right, left float64) {
+	o.update()
+	o.right, o.left = right, left
+}
+
+// readDistance returns whole millimeters traveled since the last call.
+func (o *odometry) readDistance() int16 {
+	o.update()
+	d := clampInt16(o.distance)
+	o.distance -= float64(d)
+	return d
+}
+
+// readAngle returns whole degrees turned since the last call.
+func (o *odometry) readAngle() int16 {
+	o.update()
+	a := clampInt16(o.angle)
+	o.angle -= float64(a)
+	return a
+}
+
+// clampInt16 truncates v towards zero and caps it to the int16 range, like
+// the robot does when the sensors aren't polled often enough.
+func clampInt16(v float64) int16 {
+	v = math.Trunc(v)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
@@ -12,6 +12,8 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/infinities-within/go-roomba"
 	"github.com/infinities-within/go-roomba/constants"
@@ -21,16 +23,47 @@ import (
 // function.
 type RoombaSimulator struct {
 	rw           io.ReadWriter
-	writeQ       chan []byte
-	WrittenBytes bytes.Buffer // Logs all the bytes written by the simulator to its Writer.
-	ReadBytes    bytes.Buffer // Logs all the bytes read by the simulator from its Reader.
+	in           *io.PipeReader // Closed by Stop to unblock a pending read.
+	out          *io.PipeWriter // Closed by Stop, so the driver reads EOF.
+	frameQ       chan []byte    // Stream frames to write, dropped when it's full.
+	replyReady   chan struct{}  // Signaled when replies are queued.
+	WrittenBytes bytes.Buffer   // Logs all the bytes written by the simulator to its Writer.
+	ReadBytes    bytes.Buffer   // Logs all the bytes read by the simulator from its Reader, see ReadLog.
+	logMu        sync.Mutex     // Guards ReadBytes while the simulator runs.
 
+	// The data of the last Drive command, guarded by mu. See RequestedDrive.
 	RequestedVelocity []byte
 	RequestedRadius   []byte
+
+	odometry odometry // Backs the distance and angle sensors, guarded by mu.
+
+	// Guarded by mu.
+	songNumber  []byte // Last song played, if any.
+	songPlaying bool   // Set by Play. The simulated song never ends.
+
+	done     chan struct{}  // Closed by Stop.
+	stopOnce sync.Once      // Guards closing done and the pipes.
+	stopped  chan struct{}  // Closed when serve has returned.
+	workers  sync.WaitGroup // The goroutines writing replies and stream frames.
+
+	mu           sync.Mutex
+	sensorValues map[constants.SensorCode][]byte   // Mock sensor values.
+	sequences    map[constants.SensorCode][][]byte // Values for the next reads, set by SetSensorSequence.
+	replay       [][]byte                          // Recorded stream frames sent instead of mock values.
+	streamIds    []constants.SensorCode            // Packets of the requested stream.
+	streaming    bool                              // Whether a stream was requested.
+	streamPaused bool
+	replies      [][]byte // Replies to write, never dropped.
 }
 
-// MockSensorValues contains mapping of sensor codes to sensor values returned
-// by a RoombaSimulator object on sensor requests.
+// streamPeriod is the time between stream frames, as on the robot.
+const streamPeriod = 15 * time.Millisecond
+
+// MockSensorValues contains mapping of sensor codes to the default sensor
+// values returned by a RoombaSimulator object on sensor requests. It's copied
+// into each new simulator, whose values can be changed with SetSensor.
+// Distance and angle are simulated from the drive commands, and the number of
+// stream packets from the stream request, unless they have a mock value.
 var MockSensorValues = map[constants.SensorCode][]byte{
 	constants.SENSOR_BUMP_WHEELS_DROPS:       []byte{3},
 	constants.SENSOR_VIRTUAL_WALL:            []byte{5},
@@ -38,7 +71,6 @@ var MockSensorValues = map[constants.SensorCode][]byte{
 	constants.SENSOR_TEMPERATURE:             []byte{25},
 	constants.SENSOR_OI_MODE:                 []byte{2},
 	constants.SENSOR_SONG_NUMBER:             []byte{1},
-	constants.SENSOR_DISTANCE:                []byte{10, 20},
 	constants.SENSOR_WALL:                    []byte{35},
 	constants.SENSOR_BATTERY_CHARGE:          roomba.Pack([]interface{}{uint16(1000)}),
 	constants.SENSOR_BATTERY_CAPACITY:        roomba.Pack([]interface{}{uint16(1500)}),
@@ -47,119 +79,327 @@ var MockSensorValues = map[constants.SensorCode][]byte{
 }
 
 func (sim *RoombaSimulator) serve() {
-	// Write bytes from channel asynchronously.
+	// Write replies and stream frames asynchronously, replies first.
+	sim.workers.Add(2)
 	go func() {
+		defer sim.workers.Done()
 		for {
-			bs := <-sim.writeQ
-			if len(bs) == 0 {
-				break
+			select {
+			case <-sim.replyReady:
+				for _, bs := range sim.takeReplies() {
+					sim.rw.Write(bs)
+				}
+			case bs := <-sim.frameQ:
+				sim.rw.Write(bs)
+			case <-sim.done:
+				return
 			}
-			sim.rw.Write(bs)
 		}
 	}()
+	go func() {
+		defer sim.workers.Done()
+		sim.serveStream()
+	}()
 
+	defer close(sim.stopped)
 	for {
-		sim.executeCMD()
+		if err := sim.executeCMD(); err != nil {
+			select {
+			case <-sim.done:
+			default:
+				log.Printf("error reading in RoombaSimulator: %v", err)
+			}
+			return
+		}
 	}
 }
 
+// Stop stops the simulator. It unblocks a pending read of a command or write
+// of a reply and returns once all of the simulator's goroutines have exited.
+// The driver's reads return io.EOF and its writes io.ErrClosedPipe
+// afterwards. Stopping a stopped simulator does nothing.
 func (sim *RoombaSimulator) Stop() {
-	sim.writeQ <- []byte{}
+	sim.stopOnce.Do(func() {
+		close(sim.done)
+		sim.in.Close()
+		sim.out.Close()
+	})
+	<-sim.stopped
+	sim.workers.Wait()
+}
+
+// serveStream sends a frame of the requested stream every streamPeriod until
+// the stream is paused.
+func (sim *RoombaSimulator) serveStream() {
+	ticker := time.NewTicker(streamPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-sim.done:
+			return
+		}
+		sim.mu.Lock()
+		if !sim.streaming || sim.streamPaused {
+			sim.mu.Unlock()
+			continue
+		}
+		packetIds := sim.streamIds
+		var frame []byte
+		if len(sim.replay) > 0 {
+			frame = sim.replay[0]
+			sim.replay = sim.replay[1:]
+		}
+		sim.mu.Unlock()
+
+		if frame != nil {
+			log.Printf("replaying stream frame: %v", frame)
+		} else {
+			frame = sim.streamFrame(packetIds)
+		}
+		sim.writeFrame(frame)
+	}
+}
+
+// streamFrame builds a stream frame with the current values of the given
+// packets.
+func (sim *RoombaSimulator) streamFrame(packetIds []constants.SensorCode) []byte {
+	values := make(map[constants.SensorCode][]byte, len(packetIds))
+	for _, packetId := range packetIds {
+		values[packetId] = sim.sensorValue(packetId)
+	}
+	return roomba.BuildStreamFrame(values, packetIds)
+}
+
+// Reset puts the simulator back into its initial state: the sensors report
+// MockSensorValues, nothing is driving, no song is playing and no stream is
+// requested. It lets tests share a simulator without affecting each other.
+func (sim *RoombaSimulator) Reset() {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.RequestedRadius = []byte{0, 0}
+	sim.RequestedVelocity = []byte{0, 0}
+	sim.odometry = odometry{}
+	sim.songNumber = nil
+	sim.songPlaying = false
+
+	sim.sensorValues = make(map[constants.SensorCode][]byte, len(MockSensorValues))
+	for code, value := range MockSensorValues {
+		sim.sensorValues[code] = value
+	}
+	sim.sequences = make(map[constants.SensorCode][][]byte)
+	sim.replay = nil
+	sim.streamIds = nil
+	sim.streaming = false
+	sim.streamPaused = false
+}
+
+// SetSensor sets the value the simulator reports for a sensor packet.
+func (sim *RoombaSimulator) SetSensor(code constants.SensorCode, value []byte) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.sensorValues[code] = value
+}
+
+// SetSensorSequence makes the simulator report the given values for a sensor
+// packet, one per read. The last value is kept once they're all read.
+func (sim *RoombaSimulator) SetSensorSequence(code constants.SensorCode, values [][]byte) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.sequences[code] = values
+}
+
+// LoadReplay makes the simulator send the given recorded stream frames, one
+// per period and in order, instead of frames built from the sensor values.
+// Once they're all sent, the stream goes on with frames built from the sensor
+// values. Each frame must be complete, from the 19 header to the checksum.
+func (sim *RoombaSimulator) LoadReplay(frames [][]byte) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.replay = frames
 }
 
+// Inject makes the simulator send b unsolicited, like the bytes some
+// firmwares send when switching modes.
+func (sim *RoombaSimulator) Inject(b []byte) {
+	sim.write(b)
+}
+
+// executeCMD reads a command and its data and executes it. It returns an
+// error if the port can't be read anymore, e.g. after Stop.
 func (sim *RoombaSimulator) executeCMD() error {
-	cmdBuf := sim.read(1)
-	if len(cmdBuf) != 1 {
-		return fmt.Errorf("failed reading opcode")
+	cmdBuf, err := sim.read(1)
+	if err != nil {
+		return fmt.Errorf("failed reading opcode: %w", err)
 	}
 	switch constants.OpCode(cmdBuf[0]) {
 	case constants.Sensors:
-		packetId := constants.SensorCode(sim.read(1)[0])
-		value, ok := MockSensorValues[packetId]
-		if !ok {
-			if packetId == constants.SENSOR_REQUESTED_RADIUS {
-				value = sim.RequestedRadius
-			} else if packetId == constants.SENSOR_REQUESTED_VELOCITY {
-				value = sim.RequestedVelocity
-			} else {
-				log.Printf("no mock value for sensor packet id %d", packetId)
-			}
+		id, err := sim.read(1)
+		if err != nil {
+			return err
 		}
+		packetId := constants.SensorCode(id[0])
+		value := sim.sensorValue(packetId)
 		log.Printf("sensor %d value: %v", packetId, value)
 		sim.write(value)
 	case constants.QueryList:
-		nPackets := sim.read(1)[0]
-		for i := 0; i < int(nPackets); i++ {
-			packetId := constants.SensorCode(sim.read(1)[0])
-			value, ok := MockSensorValues[packetId]
-			if !ok {
-				if packetId == constants.SENSOR_REQUESTED_RADIUS {
-					value = sim.RequestedRadius
-				} else if packetId == constants.SENSOR_REQUESTED_VELOCITY {
-					value = sim.RequestedVelocity
-				} else {
-					log.Printf("no mock value for sensor packet id %d", packetId)
-				}
-			}
+		nPackets, err := sim.read(1)
+		if err != nil {
+			return err
+		}
+		ids, err := sim.read(int(nPackets[0]))
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			packetId := constants.SensorCode(id)
+			value := sim.sensorValue(packetId)
 			log.Printf("sensor %d value: %v", packetId, value)
 			sim.write(value)
 		}
 	case constants.SensorStream:
-		nBytes := sim.read(1)[0]
-		packetIds := make([]constants.SensorCode, nBytes)
-		for i := byte(0); i < nBytes; i++ {
-			packetIds[i] = constants.SensorCode(sim.read(1)[0])
-		}
-		// Contains just packet ids and values, no headers.
-		sensorValues := bytes.Buffer{}
-		for i := byte(0); i < nBytes; i++ {
-			mockValue, ok := MockSensorValues[packetIds[i]]
-			if !ok {
-				log.Printf("no mock value for streaming packet id: %d", packetIds[i])
-				mockValue = make([]byte, constants.SENSOR_PACKET_LENGTH[packetIds[i]])
-			} else {
-				log.Printf("sensor %d value: %v", packetIds[i], mockValue)
-			}
-			sensorValues.WriteByte(byte(packetIds[i]))
-			sensorValues.Write(mockValue)
-		}
-
-		output := bytes.Buffer{}
-		// Header.
-		output.WriteByte(19)
-		// Data length.
-		messageLen := byte(sensorValues.Len())
-		log.Printf("message length: %d", messageLen)
-		output.WriteByte(messageLen)
-		output.Write(sensorValues.Bytes())
-		checksum := byte(0)
-		for _, b := range output.Bytes()[1:] {
-			checksum -= b
-		}
-		output.WriteByte(checksum)
-		log.Printf("checksum: %d", checksum)
-
-		sim.write(output.Bytes())
+		nBytes, err := sim.read(1)
+		if err != nil {
+			return err
+		}
+		ids, err := sim.read(int(nBytes[0]))
+		if err != nil {
+			return err
+		}
+		packetIds := make([]constants.SensorCode, len(ids))
+		for i, id := range ids {
+			packetIds[i] = constants.SensorCode(id)
+		}
+		sim.mu.Lock()
+		sim.streamIds = packetIds
+		sim.streaming = true
+		sim.streamPaused = false
+		sim.mu.Unlock()
+		log.Printf("streaming packets %v", packetIds)
+	case constants.Reset:
+		log.Printf("reset")
 	case constants.Start:
 		log.Printf("switched to passive mode")
-	case constants.Safe:
+		sim.SetSensor(constants.SENSOR_OI_MODE, []byte{1})
+	case constants.Safe, constants.Control:
 		log.Printf("switched to safe mode")
+		sim.SetSensor(constants.SENSOR_OI_MODE, []byte{2})
+	case constants.Full:
+		log.Printf("switched to full mode")
+		sim.SetSensor(constants.SENSOR_OI_MODE, []byte{3})
+	case constants.Stop:
+		log.Printf("OI stopped")
+		sim.SetSensor(constants.SENSOR_OI_MODE, []byte{0})
+	case constants.Dock:
+		log.Printf("seeking dock")
+		sim.SetSensor(constants.SENSOR_OI_MODE, []byte{1})
+	case constants.Power:
+		log.Printf("powered down")
+		sim.SetSensor(constants.SENSOR_OI_MODE, []byte{1})
+	case constants.Demo:
+		data, err := sim.read(1)
+		if err != nil {
+			return err
+		}
+		demo := data[0]
+		if demo == 255 {
+			log.Printf("demo stopped")
+		} else {
+			log.Printf("running demo %d", demo)
+		}
+		sim.SetSensor(constants.SENSOR_OI_MODE, []byte{1})
 	case constants.PauseResumeStream:
-		if sim.read(1)[0] == byte(0) {
+		data, err := sim.read(1)
+		if err != nil {
+			return err
+		}
+		paused := data[0] == byte(0)
+		sim.mu.Lock()
+		sim.streamPaused = paused
+		sim.mu.Unlock()
+		if paused {
 			log.Printf("stream paused")
 		} else {
 			log.Printf("stream resumed")
 		}
 	case constants.DriveDirect:
-		data := sim.read(4)
+		data, err := sim.read(4)
+		if err != nil {
+			return err
+		}
+		if sim.isOff() {
+			log.Printf("ignoring DirectDrive in off mode")
+			break
+		}
 		var rightVelocity, leftVelocity int16
 		_ = binary.Read(bytes.NewReader(data[:2]), binary.BigEndian, &rightVelocity)
 		_ = binary.Read(bytes.NewReader(data[2:4]), binary.BigEndian, &leftVelocity)
 		log.Printf("DirectDrive: %d, %d (%v)", rightVelocity, leftVelocity, data)
+		sim.mu.Lock()
+		sim.odometry.setWheels(float64(rightVelocity), float64(leftVelocity))
+		sim.mu.Unlock()
+	case constants.DrivePWM:
+		data, err := sim.read(4)
+		if err != nil {
+			return err
+		}
+		log.Printf("DrivePWM: %v", data)
+	case constants.LowSideDrivers, constants.DigitalOutputs, constants.SendIR:
+		data, err := sim.read(1)
+		if err != nil {
+			return err
+		}
+		log.Printf("opcode %d: %v", cmdBuf[0], data)
+	case constants.Baud:
+		code, err := sim.read(1)
+		if err != nil {
+			return err
+		}
+		log.Printf("baud rate code: %v", code)
+	case constants.PWMLowSideDrivers:
+		data, err := sim.read(3)
+		if err != nil {
+			return err
+		}
+		log.Printf("low side driver duty cycles: %v", data)
+	case constants.Song:
+		header, err := sim.read(2)
+		if err != nil {
+			return err
+		}
+		notes, err := sim.read(2 * int(header[1]))
+		if err != nil {
+			return err
+		}
+		log.Printf("stored song %d: %v", header[0], notes)
+	case constants.Play:
+		song, err := sim.read(1)
+		if err != nil {
+			return err
+		}
+		sim.mu.Lock()
+		sim.songNumber = song
+		sim.songPlaying = true
+		sim.mu.Unlock()
+		log.Printf("playing song %v", song)
 	case constants.Drive:
-		sim.RequestedVelocity = sim.read(2)
-		sim.RequestedRadius = sim.read(2)
-		log.Printf("Drive: %d, %d", sim.RequestedVelocity, sim.RequestedRadius)
+		data, err := sim.read(4)
+		if err != nil {
+			return err
+		}
+		if sim.isOff() {
+			log.Printf("ignoring Drive in off mode")
+			break
+		}
+		log.Printf("Drive: %d, %d", data[:2], data[2:])
+		var velocity, radius int16
+		_ = binary.Read(bytes.NewReader(data[:2]), binary.BigEndian, &velocity)
+		_ = binary.Read(bytes.NewReader(data[2:]), binary.BigEndian, &radius)
+		sim.mu.Lock()
+		sim.RequestedVelocity = data[:2]
+		sim.RequestedRadius = data[2:]
+		sim.odometry.drive(velocity, radius)
+		sim.mu.Unlock()
 	default:
 		log.Printf("unknown opcode: %d", cmdBuf[0])
 	}
@@ -167,26 +407,139 @@ func (sim *RoombaSimulator) executeCMD() error {
 	return nil
 }
 
-// Reads given number of bytes from the Reader sim.rw.
-func (sim *RoombaSimulator) read(n int) []byte {
-	buf := make([]byte, n)
-	nRead, err := sim.rw.Read(buf)
-	if n != nRead {
-		if err != nil {
-			log.Printf("error reading in RoombaSimulator: %v", err)
+// Reports whether the simulated OI is in Off mode, where it ignores drive
+// commands.
+func (sim *RoombaSimulator) isOff() bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	mode := sim.sensorValues[constants.SENSOR_OI_MODE]
+	return len(mode) == 1 && mode[0] == 0
+}
+
+// Returns the value of the given sensor packet reported by the simulator.
+// Group packets without a mock value are made of the values of their
+// packets. Sensors without a mock or simulated value read as zeros.
+func (sim *RoombaSimulator) sensorValue(packetId constants.SensorCode) []byte {
+	switch {
+	case packetId == constants.SENSOR_REQUESTED_RADIUS:
+		_, radius := sim.RequestedDrive()
+		return radius
+	case packetId == constants.SENSOR_REQUESTED_VELOCITY:
+		velocity, _ := sim.RequestedDrive()
+		return velocity
+	}
+	sim.mu.Lock()
+	switch {
+	case packetId == constants.SENSOR_SONG_NUMBER && sim.songNumber != nil:
+		song := sim.songNumber
+		sim.mu.Unlock()
+		return song
+	case packetId == constants.SENSOR_SONG_PLAYING && sim.songPlaying:
+		sim.mu.Unlock()
+		return []byte{1}
+	}
+	if sequence := sim.sequences[packetId]; len(sequence) > 0 {
+		sim.sensorValues[packetId] = sequence[0]
+		sim.sequences[packetId] = sequence[1:]
+	}
+	value, ok := sim.sensorValues[packetId]
+	streamed := len(sim.streamIds)
+	switch {
+	case !ok && packetId == constants.SENSOR_DISTANCE:
+		value, ok = roomba.Pack([]interface{}{sim.odometry.readDistance()}), true
+	case !ok && packetId == constants.SENSOR_ANGLE:
+		value, ok = roomba.Pack([]interface{}{sim.odometry.readAngle()}), true
+	}
+	sim.mu.Unlock()
+	if !ok && packetId == constants.SENSOR_NUM_STREAM_PACKETS {
+		return []byte{byte(streamed)}
+	}
+	if packetIds, isGroup := constants.SENSOR_GROUP_PACKETS[packetId]; !ok && isGroup {
+		group := bytes.Buffer{}
+		for _, id := range packetIds {
+			group.Write(sim.sensorValue(id))
 		}
-		//log.Printf("read %d bytes when expected %d", nRead, n)
-		return []byte{}
+		return group.Bytes()
+	}
+	if !ok {
+		log.Printf("no mock value for sensor packet id %d", packetId)
+		value = make([]byte, constants.SENSOR_PACKET_LENGTH[packetId])
+	}
+	return value
+}
+
+// Reads given number of bytes from the Reader sim.rw. It returns an error if
+// fewer bytes could be read.
+func (sim *RoombaSimulator) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(sim.rw, buf); err != nil {
+		return nil, err
 	}
 	log.Printf("roomba reads: %v", buf)
+	sim.logMu.Lock()
 	sim.ReadBytes.Write(buf)
-	return buf
+	sim.logMu.Unlock()
+	return buf, nil
+}
+
+// ReadLog removes up to n bytes from ReadBytes and returns them. Unlike
+// reading ReadBytes directly, it's safe while the simulator runs.
+func (sim *RoombaSimulator) ReadLog(n int) []byte {
+	sim.logMu.Lock()
+	defer sim.logMu.Unlock()
+	return append([]byte(nil), sim.ReadBytes.Next(n)...)
 }
 
-// Writes bytes to the Writer w asynchronously.
+// RequestedDrive returns the velocity and radius of the last Drive command.
+// Unlike reading RequestedVelocity and RequestedRadius directly, it's safe
+// while the simulator runs.
+func (sim *RoombaSimulator) RequestedDrive() (velocity, radius []byte) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.RequestedVelocity, sim.RequestedRadius
+}
+
+// Writes a reply to the Writer w asynchronously. Replies are queued without
+// limit, so that executeCMD never blocks, and are never dropped, so that the
+// driver doesn't read a later reply in place of a missing one.
 func (sim *RoombaSimulator) write(b []byte) {
 	log.Printf("roomba says: %v", b)
-	sim.writeQ <- b
+	sim.mu.Lock()
+	sim.replies = append(sim.replies, b)
+	sim.mu.Unlock()
+	select {
+	case sim.replyReady <- struct{}{}:
+	default:
+	}
+}
+
+// takeReplies returns the queued replies and empties the queue.
+func (sim *RoombaSimulator) takeReplies() [][]byte {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	replies := sim.replies
+	sim.replies = nil
+	return replies
+}
+
+// Writes a stream frame to the Writer w asynchronously. If the queue is full,
+// e.g. because nobody reads the stream, the oldest queued frames are dropped.
+func (sim *RoombaSimulator) writeFrame(b []byte) {
+	log.Printf("roomba streams: %v", b)
+	for {
+		select {
+		case sim.frameQ <- b:
+			return
+		case <-sim.done:
+			return
+		default:
+		}
+		select {
+		case dropped := <-sim.frameQ:
+			log.Printf("frame queue full, dropped: %v", dropped)
+		default:
+		}
+	}
 }
 
 // Helper for merging reader and writer into a ReadWriter.
@@ -212,12 +565,15 @@ func MakeRoombaSim() (*RoombaSimulator, *readWriter) {
 			// Log all written bytes to writtenBytes.
 			io.MultiWriter(out_w, writtenBytes),
 		},
-		writeQ:    make(chan []byte, 15),
-		ReadBytes: *readBytes,
-
-		RequestedRadius:   []byte{0, 0},
-		RequestedVelocity: []byte{0, 0},
+		in:         inp_r,
+		out:        out_w,
+		frameQ:     make(chan []byte, 15),
+		replyReady: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		ReadBytes:  *readBytes,
 	}
+	sim.Reset()
 	go sim.serve()
 
 	rw := &readWriter{out_r, inp_w}
@@ -0,0 +1,248 @@
+package sim_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	"github.com/infinities-within/go-roomba/sim"
+)
+
+func TestLoadReplay(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+	r := &roomba.Roomba{S: rw, StreamPaused: make(chan bool, 1)}
+
+	s.LoadReplay([][]byte{
+		{19, 2, 13, 1, 221},
+		{19, 2, 13, 0, 222},
+	})
+	out, err := r.Stream([]constants.SensorCode{constants.SENSOR_VIRTUAL_WALL})
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	for i, expected := range []byte{1, 0} {
+		frame := <-out
+		if len(frame) != 1 || len(frame[0]) != 1 || frame[0][0] != expected {
+			t.Errorf("replayed frame %d: expected virtual wall %d, got %v", i, expected, frame)
+		}
+	}
+}
+
+func TestSetSensorPerInstance(t *testing.T) {
+	s1, rw1 := sim.MakeRoombaSim()
+	defer s1.Stop()
+	s2, rw2 := sim.MakeRoombaSim()
+	defer s2.Stop()
+	r1 := &roomba.Roomba{S: rw1}
+	r2 := &roomba.Roomba{S: rw2}
+
+	s1.SetSensor(constants.SENSOR_TEMPERATURE, []byte{30})
+	s2.SetSensor(constants.SENSOR_TEMPERATURE, []byte{40})
+
+	for _, c := range []struct {
+		r        *roomba.Roomba
+		expected byte
+	}{{r1, 30}, {r2, 40}} {
+		value, err := c.r.Sensors(constants.SENSOR_TEMPERATURE)
+		if err != nil {
+			t.Fatalf("error reading temperature: %s", err)
+		}
+		if value[0] != c.expected {
+			t.Errorf("temperature is %d, expected %d", value[0], c.expected)
+		}
+	}
+	if sim.MockSensorValues[constants.SENSOR_TEMPERATURE][0] != 25 {
+		t.Errorf("SetSensor changed the default mock values")
+	}
+}
+
+func TestWriteQueueFull(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+
+	// Nothing reads the replies, so the stream and the sensor replies fill
+	// the write queue.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rw.Write([]byte{148, 1, 7})
+		time.Sleep(300 * time.Millisecond)
+		for i := 0; i < 20; i++ {
+			rw.Write([]byte{142, 25})
+		}
+		rw.Write([]byte{137, 0, 100, 0, 0})
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("simulator stopped reading commands")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if velocity, _ := s.RequestedDrive(); !bytes.Equal(velocity, []byte{0, 100}) {
+		t.Errorf("requested velocity is %v, expected [0 100]", velocity)
+	}
+}
+
+func TestRepliesNotDropped(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+	s.SetSensor(constants.SENSOR_BATTERY_CHARGE, []byte{0xab, 0xcd})
+
+	// The stream fills the queue before the replies are read.
+	rw.Write([]byte{148, 1, 7})
+	time.Sleep(300 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		rw.Write([]byte{142, 25})
+	}
+	var read []byte
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(2 * time.Second)
+	for bytes.Count(read, []byte{0xab, 0xcd}) < 20 && time.Now().Before(deadline) {
+		n, _ := rw.Read(buf)
+		read = append(read, buf[:n]...)
+	}
+	if n := bytes.Count(read, []byte{0xab, 0xcd}); n != 20 {
+		t.Errorf("read %d replies, expected 20", n)
+	}
+}
+
+func TestStopDuringRead(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+
+	// Send just the Sensors opcode, so the simulator waits for the packet id.
+	rw.Write([]byte{142})
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Stop didn't return while the simulator was reading")
+	}
+	if n, err := rw.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after Stop returned %d, %v, expected EOF", n, err)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+
+	// A command's data may arrive in several writes.
+	rw.Write([]byte{137, 0})
+	time.Sleep(10 * time.Millisecond)
+	rw.Write([]byte{100, 0, 0})
+	rw.Write([]byte{142, 39})
+	velocity := make([]byte, 2)
+	if _, err := io.ReadFull(rw, velocity); err != nil {
+		t.Fatalf("reading the velocity failed: %v", err)
+	}
+	if !bytes.Equal(velocity, []byte{0, 100}) {
+		t.Errorf("requested velocity is %v, expected [0 100]", velocity)
+	}
+}
+
+func TestStreamDistanceWhileDriving(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+	go io.Copy(ioutil.Discard, rw)
+
+	// The stream reads the odometry while the drive commands update it.
+	rw.Write([]byte{148, 2, 19, 20})
+	for i := 0; i < 20; i++ {
+		rw.Write([]byte{137, 0, 100, 0, 0})
+		rw.Write([]byte{145, 0, 50, 0, 60})
+		time.Sleep(5 * time.Millisecond)
+	}
+	rw.Write([]byte{142, 19})
+}
+
+func TestStreamSongWhilePlaying(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+	go io.Copy(ioutil.Discard, rw)
+
+	// The stream reads the song state while Play sets it.
+	rw.Write([]byte{148, 2, 36, 37})
+	for i := 0; i < 20; i++ {
+		rw.Write([]byte{141, byte(i % 5)})
+		time.Sleep(5 * time.Millisecond)
+	}
+	rw.Write([]byte{142, 36})
+}
+
+func TestStopStress(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		s, rw := sim.MakeRoombaSim()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		// Keep the simulator busy reading commands and writing replies and
+		// stream frames while it's stopped.
+		go func() {
+			defer wg.Done()
+			rw.Write([]byte{148, 1, 7})
+			for {
+				if _, err := rw.Write([]byte{142, 25, 137, 0, 100, 0, 0}); err != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			io.Copy(ioutil.Discard, rw)
+		}()
+		time.Sleep(time.Duration(i%5) * time.Millisecond)
+		s.Stop()
+		s.Stop()
+		wg.Wait()
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines left running, %d before", after, before)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s, rw := sim.MakeRoombaSim()
+	defer s.Stop()
+	r := &roomba.Roomba{S: rw, StreamPaused: make(chan bool, 1)}
+
+	s.SetSensor(constants.SENSOR_TEMPERATURE, []byte{40})
+	s.SetSensorSequence(constants.SENSOR_WALL, [][]byte{{1}, {0}})
+	r.Drive(200, -1)
+	r.Play(3)
+	if _, err := r.Sensors(constants.SENSOR_OI_MODE); err != nil {
+		t.Fatalf("error reading OI mode: %s", err)
+	}
+
+	s.Reset()
+	for _, c := range []struct {
+		code     constants.SensorCode
+		expected []byte
+	}{
+		{constants.SENSOR_TEMPERATURE, []byte{25}},
+		{constants.SENSOR_WALL, []byte{35}},
+		{constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 0}},
+		{constants.SENSOR_REQUESTED_RADIUS, []byte{0, 0}},
+		{constants.SENSOR_SONG_NUMBER, []byte{1}},
+		{constants.SENSOR_SONG_PLAYING, []byte{0}},
+		{constants.SENSOR_ANGLE, []byte{0, 0}},
+	} {
+		value, err := r.Sensors(c.code)
+		if err != nil {
+			t.Fatalf("error reading sensor %d: %s", c.code, err)
+		}
+		if !bytes.Equal(value, c.expected) {
+			t.Errorf("sensor %d is %v after Reset, expected %v", c.code, value, c.expected)
+		}
+	}
+}
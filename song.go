@@ -0,0 +1,124 @@
+// Songs stored with the Song command.
+
+package roomba
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+const (
+	// maxSongNotes is the most notes the OI stores in a song.
+	maxSongNotes = 16
+	// noteTick is the unit of note durations.
+	noteTick = time.Second / 64
+	// Notes outside this range of MIDI note numbers are played as rests.
+	minNoteNumber = 31
+	maxNoteNumber = 127
+)
+
+// Note is a note of a song. Number is the MIDI note number, from 31 (G) to
+// 127 (G); other numbers are rests. Duration is in 1/64 of a second.
+type Note struct {
+	Number   byte
+	Duration byte
+}
+
+// Notes are the notes of a song, in the order they're played.
+type Notes []Note
+
+// TotalDuration returns how long the notes take to play.
+func (n Notes) TotalDuration() time.Duration {
+	var ticks int
+	for _, note := range n {
+		ticks += int(note.Duration)
+	}
+	return time.Duration(ticks) * noteTick
+}
+
+// maxSongNumber returns the highest song number of the declared model.
+// Without a declared model, the 16 songs of Create are allowed.
+func (roomba *Roomba) maxSongNumber() byte {
+	if roomba.roombaOI() {
+		return 4
+	}
+	return 15
+}
+
+// Song command stores a song to be played later with Play. Song number is in
+// range 0-15 on Create and 0-4 on Roombas and Create 2, as declared by Model.
+// A song has 1 to 16 notes, so it lasts at most 63.75 s. The OI would store
+// only the first notes of a longer song, so it's an error. Songs can be
+// played in a row by storing them with consecutive numbers.
+func (roomba *Roomba) Song(songNumber byte, notes Notes) error {
+	if songNumber > roomba.maxSongNumber() {
+		return fmt.Errorf("invalid song number: %d", songNumber)
+	}
+	if len(notes) == 0 || len(notes) > maxSongNotes {
+		return fmt.Errorf("invalid song length: %d notes, %v; at most %d notes",
+			len(notes), notes.TotalDuration(), maxSongNotes)
+	}
+	data := make([]byte, 0, 2+2*len(notes))
+	data = append(data, songNumber, byte(len(notes)))
+	for i, note := range notes {
+		if note.Number < minNoteNumber || note.Number > maxNoteNumber {
+			log.Printf("song %d: note %d number %d is out of range, it's a rest",
+				songNumber, i, note.Number)
+		}
+		data = append(data, note.Number, note.Duration)
+	}
+	return roomba.Write(constants.Song, data)
+}
+
+// beepSong is the song number Beep stores its notes in. It's the last song
+// of Roombas and Create 2, which store only songs 0-4.
+const beepSong = 4
+
+// Beep plays notes right away, e.g. for quick audio feedback. It stores them
+// as song 4 and plays that song, so it replaces the song stored there, as
+// FindMe does; use songs 0-3 for songs stored with Song.
+func (roomba *Roomba) Beep(notes Notes) error {
+	if err := roomba.Song(beepSong, notes); err != nil {
+		return err
+	}
+	return roomba.Play(beepSong)
+}
+
+// The song and LED flashes of FindMe.
+const (
+	findMeSong          = 4
+	findMeFlashes       = 3
+	findMeFlashInterval = 250 * time.Millisecond
+)
+
+// findMeNotes are a short rising chime.
+var findMeNotes = Notes{{76, 8}, {79, 8}, {84, 16}}
+
+// FindMe helps locating the robot, e.g. when it's stuck under furniture: it
+// plays a chime and flashes the Play and Advance LEDs and the power LED in
+// red a few times. The chime is stored as song 4, replacing the song stored
+// there. The LEDs are then restored as set by the last LEDs command, which
+// may be sent while the LEDs flash.
+func (roomba *Roomba) FindMe() error {
+	if err := roomba.Song(findMeSong, findMeNotes); err != nil {
+		return err
+	}
+	if err := roomba.Play(findMeSong); err != nil {
+		return err
+	}
+	on := ledState{bits: ledPlay | ledAdvance, color: byte(Red), intensity: 255}
+	for i := 0; i < findMeFlashes; i++ {
+		if err := roomba.flashLEDs(on); err != nil {
+			return err
+		}
+		time.Sleep(findMeFlashInterval)
+		if err := roomba.restoreLEDs(); err != nil {
+			return err
+		}
+		time.Sleep(findMeFlashInterval)
+	}
+	return nil
+}
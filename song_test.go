@@ -0,0 +1,134 @@
+package roomba_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestNotesTotalDuration(t *testing.T) {
+	notes := roomba.Notes{{60, 32}, {62, 16}, {0, 16}}
+	if d := notes.TotalDuration(); d != time.Second {
+		t.Errorf("total duration is %v, expected 1s", d)
+	}
+}
+
+func TestSong(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	if err := r.Song(1, roomba.Notes{{60, 32}, {67, 64}}); err != nil {
+		t.Fatalf("error storing song: %s", err)
+	}
+	long := make(roomba.Notes, 17)
+	for i := range long {
+		long[i] = roomba.Note{60, 255}
+	}
+	if err := r.Song(2, long); err == nil {
+		t.Errorf("stored a song of 17 notes")
+	}
+	if err := r.Song(2, long[:16]); err != nil {
+		t.Errorf("error storing a song of 16 notes: %s", err)
+	}
+	if err := r.Song(16, roomba.Notes{{60, 32}}); err == nil {
+		t.Errorf("stored song number 16")
+	}
+	expected := []byte{140, 1, 2, 60, 32, 67, 64, 140, 2, 16}
+	for range long[:16] {
+		expected = append(expected, 60, 255)
+	}
+	rt.VerifyWritten(r, expected, t)
+}
+
+func TestSongNumberByModel(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	notes := roomba.Notes{{60, 32}}
+
+	for _, model := range []roomba.Model{roomba.ModelRoomba500, roomba.ModelCreate2} {
+		r.Model = model
+		if err := r.Song(5, notes); err == nil {
+			t.Errorf("model %d stored song number 5", model)
+		}
+		if err := r.Play(5); err == nil {
+			t.Errorf("model %d played song number 5", model)
+		}
+		if err := r.Song(4, notes); err != nil {
+			t.Errorf("model %d failed storing song number 4: %s", model, err)
+		}
+	}
+	r.Model = roomba.ModelCreate
+	if err := r.Song(15, notes); err != nil {
+		t.Errorf("Create failed storing song number 15: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{140, 4, 1, 60, 32, 140, 4, 1, 60, 32, 140, 15, 1, 60, 32}, t)
+}
+
+func TestFindMe(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.LEDs(false, true, 0, 128)
+	if err := r.FindMe(); err != nil {
+		t.Fatalf("FindMe failed: %s", err)
+	}
+	expected := []byte{
+		139, 2, 0, 128,
+		140, 4, 3, 76, 8, 79, 8, 84, 16,
+		141, 4,
+	}
+	for i := 0; i < 3; i++ {
+		expected = append(expected, 139, 10, 255, 255, 139, 2, 0, 128)
+	}
+	rt.VerifyWritten(r, expected, t)
+}
+
+func TestFindMeKeepsLEDChanges(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	r.LEDs(false, true, 0, 128)
+
+	done := make(chan error)
+	go func() { done <- r.FindMe() }()
+	// Change an LED while the first flash is shown. It's sent right away and
+	// restored after each flash.
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if err := r.SetAdvanceLED(true); err != nil {
+		t.Fatalf("SetAdvanceLED failed: %s", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("SetAdvanceLED waited %v for FindMe", d)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("FindMe failed: %s", err)
+	}
+	expected := []byte{
+		139, 2, 0, 128,
+		140, 4, 3, 76, 8, 79, 8, 84, 16,
+		141, 4,
+		139, 10, 255, 255, 139, 10, 0, 128, 139, 10, 0, 128,
+	}
+	for i := 0; i < 2; i++ {
+		expected = append(expected, 139, 10, 255, 255, 139, 10, 0, 128)
+	}
+	rt.VerifyWritten(r, expected, t)
+}
+
+func TestBeep(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	if err := r.Beep(roomba.Notes{{72, 8}, {76, 8}}); err != nil {
+		t.Fatalf("error beeping: %s", err)
+	}
+	rt.VerifyWritten(r, []byte{140, 4, 2, 72, 8, 76, 8, 141, 4}, t)
+	if err := r.Beep(nil); err == nil {
+		t.Errorf("beeped without notes")
+	}
+	r.Model = roomba.ModelRoomba500
+	if err := r.Beep(roomba.Notes{{72, 8}}); err != nil {
+		t.Errorf("error beeping on a 500-series Roomba: %s", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Human-readable robot status.
+
+package roomba
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// StatusString reads the OI mode, battery and charging sensors with a single
+// request and summarizes them on one line, e.g. "Safe | 78% | Charging on
+// dock".
+func (roomba *Roomba) StatusString() (string, error) {
+	v, err := roomba.QueryList([]constants.SensorCode{
+		constants.SENSOR_OI_MODE,
+		constants.SENSOR_BATTERY_CHARGE,
+		constants.SENSOR_BATTERY_CAPACITY,
+		constants.SENSOR_CHARGING,
+		constants.SENSOR_CHARGING_SOURCE,
+	})
+	if err != nil {
+		return "", err
+	}
+	mode := OIMode(v[0][0])
+	charge := decodeUint16(v[1])
+	capacity := decodeUint16(v[2])
+	state := ChargingState(v[3][0])
+	sources := decodeChargingSources(v[4][0])
+
+	battery := "?%"
+	if percent, err := batteryPercent(charge, capacity); err == nil {
+		battery = fmt.Sprintf("%.0f%%", percent)
+	}
+	charging := state.String()
+	switch state {
+	case ReconditioningCharging, FullCharging, TrickleCharging:
+		charging = "Charging"
+	}
+	if sources.HomeBase {
+		charging += " on dock"
+	}
+	return fmt.Sprintf("%v | %s | %s", mode, battery, charging), nil
+}
+
+// BatteryPercent reads the battery charge and capacity and returns the charge
+// as a percentage of the capacity, between 0 and 100. It returns an error if
+// the robot reports a capacity of 0, as it does before the battery is
+// initialized.
+func (roomba *Roomba) BatteryPercent() (float64, error) {
+	v, err := roomba.QueryList([]constants.SensorCode{
+		constants.SENSOR_BATTERY_CHARGE,
+		constants.SENSOR_BATTERY_CAPACITY,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return batteryPercent(decodeUint16(v[0]), decodeUint16(v[1]))
+}
+
+func batteryPercent(charge, capacity uint16) (float64, error) {
+	if capacity == 0 {
+		return 0, errors.New("battery capacity is 0")
+	}
+	return math.Min(100, 100*float64(charge)/float64(capacity)), nil
+}
+
+// Metrics reads the battery sensors with a single request, like PowerState,
+// and returns them keyed by metric name in base units, ready to be exported
+// as Prometheus gauges:
+//
+//	battery_percent              charge as a percentage of the capacity
+//	battery_voltage_volts
+//	battery_current_amperes      negative when discharging
+//	battery_temperature_celsius
+//	charging_state               the ChargingState value
+//
+// battery_percent is left out while the robot reports a capacity of 0.
+func (roomba *Roomba) Metrics() (map[string]float64, error) {
+	p, err := roomba.PowerState()
+	if err != nil {
+		return nil, err
+	}
+	metrics := map[string]float64{
+		"battery_voltage_volts":       float64(p.Voltage) / 1000,
+		"battery_current_amperes":     float64(p.Current) / 1000,
+		"battery_temperature_celsius": float64(p.Temperature),
+		"charging_state":              float64(p.ChargingState),
+	}
+	if percent, err := batteryPercent(p.BatteryCharge, p.BatteryCapacity); err == nil {
+		metrics["battery_percent"] = percent
+	}
+	return metrics, nil
+}
@@ -0,0 +1,98 @@
+package roomba_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestStatusString(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	// The mocks are Safe mode with 1000 of 1500 mAh, not charging.
+	s, err := r.StatusString()
+	if err != nil {
+		t.Fatalf("StatusString failed: %s", err)
+	}
+	if expected := "Safe | 67% | Not charging"; s != expected {
+		t.Errorf("status is %q, expected %q", s, expected)
+	}
+
+	rt.SetSensorValue(constants.SENSOR_OI_MODE, []byte{1})
+	rt.SetSensorValue(constants.SENSOR_CHARGING, []byte{3})
+	rt.SetSensorValue(constants.SENSOR_CHARGING_SOURCE, []byte{2})
+	rt.SetSensorValue(constants.SENSOR_BATTERY_CAPACITY, []byte{0, 0})
+	s, err = r.StatusString()
+	if err != nil {
+		t.Fatalf("StatusString failed: %s", err)
+	}
+	if expected := "Passive | ?% | Charging on dock"; s != expected {
+		t.Errorf("status is %q, expected %q", s, expected)
+	}
+	rt.VerifyWritten(r, []byte{149, 5, 35, 25, 26, 21, 34}, t)
+}
+
+func TestBatteryPercent(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	// The mocks are 1000 of 1500 mAh.
+	p, err := r.BatteryPercent()
+	if err != nil {
+		t.Fatalf("BatteryPercent failed: %s", err)
+	}
+	if math.Abs(p-66.67) > 0.01 {
+		t.Errorf("battery percent is %f, expected 66.67", p)
+	}
+
+	rt.SetSensorValue(constants.SENSOR_BATTERY_CHARGE, roomba.Pack([]interface{}{uint16(1600)}))
+	if p, err := r.BatteryPercent(); err != nil || p != 100 {
+		t.Errorf("overcharged battery percent is %f (%v), expected 100", p, err)
+	}
+
+	rt.SetSensorValue(constants.SENSOR_BATTERY_CAPACITY, []byte{0, 0})
+	if _, err := r.BatteryPercent(); err == nil {
+		t.Errorf("BatteryPercent didn't fail with capacity 0")
+	}
+	rt.VerifyWritten(r, []byte{149, 2, 25, 26}, t)
+}
+
+func TestMetrics(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_VOLTAGE, roomba.Pack([]interface{}{uint16(14500)}))
+	rt.SetSensorValue(constants.SENSOR_CHARGING, []byte{2})
+
+	m, err := r.Metrics()
+	if err != nil {
+		t.Fatalf("Metrics failed: %s", err)
+	}
+	// The mocks are 1000 of 1500 mAh, -747 mA and 25 °C.
+	expected := map[string]float64{
+		"battery_percent":             66.67,
+		"battery_voltage_volts":       14.5,
+		"battery_current_amperes":     -0.747,
+		"battery_temperature_celsius": 25,
+		"charging_state":              2,
+	}
+	if len(m) != len(expected) {
+		t.Errorf("got metrics %v, expected %v", m, expected)
+	}
+	for name, value := range expected {
+		if actual, ok := m[name]; !ok || math.Abs(actual-value) > 0.01 {
+			t.Errorf("metric %s is %v, expected %v", name, actual, value)
+		}
+	}
+
+	rt.SetSensorValue(constants.SENSOR_BATTERY_CAPACITY, []byte{0, 0})
+	if m, err := r.Metrics(); err != nil {
+		t.Errorf("Metrics failed with capacity 0: %s", err)
+	} else if _, ok := m["battery_percent"]; ok {
+		t.Errorf("battery_percent reported with capacity 0")
+	}
+	rt.VerifyWritten(r, []byte{142, 3, 142, 3}, t)
+}
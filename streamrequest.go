@@ -0,0 +1,105 @@
+package roomba
+
+import (
+	"fmt"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// maxFrameLength is the largest stream frame the OI can send, including the
+// header, N-bytes and checksum.
+const maxFrameLength = 255
+
+// StreamRequest builds the list of packets of a sensor stream, e.g.
+//
+//	req := new(roomba.StreamRequest).Add(constants.SENSOR_BUMP_WHEELS_DROPS).Add(constants.SENSOR_WALL)
+//	out, err := r.StreamRequest(req)
+type StreamRequest struct {
+	packetIds []constants.SensorCode
+}
+
+// Add appends a packet to the request.
+func (req *StreamRequest) Add(code constants.SensorCode) *StreamRequest {
+	req.packetIds = append(req.packetIds, code)
+	return req
+}
+
+// FrameLength returns the length of the stream frames of the request,
+// including the header, N-bytes and checksum. Unknown packets are counted as
+// empty.
+func (req *StreamRequest) FrameLength() int {
+	length := 3 + len(req.packetIds)
+	for _, packetId := range req.packetIds {
+		length += int(constants.SENSOR_PACKET_LENGTH[packetId])
+	}
+	return length
+}
+
+// Build validates the requested packets and returns the data bytes of the
+// SensorStream command: the number of packets followed by their ids.
+func (req *StreamRequest) Build() ([]byte, error) {
+	if _, err := PacketsTotalLength(req.packetIds); err != nil {
+		return nil, err
+	}
+	if length := req.FrameLength(); length > maxFrameLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, length)
+	}
+	b := make([]byte, 0, len(req.packetIds)+1)
+	b = append(b, byte(len(req.packetIds)))
+	for _, packetId := range req.packetIds {
+		b = append(b, byte(packetId))
+	}
+	return b, nil
+}
+
+// StreamRequest starts a stream of the packets in req, like Stream.
+func (roomba *Roomba) StreamRequest(req *StreamRequest) (<-chan [][]byte, error) {
+	data, err := req.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := roomba.Write(constants.SensorStream, data); err != nil {
+		return nil, err
+	}
+
+	packetIds := append([]constants.SensorCode(nil), req.packetIds...)
+	out := make(chan [][]byte)
+	go roomba.runStream(packetIds, out, roomba.newStream())
+	return out, nil
+}
+
+// Restream changes the packets of the stream. PauseResumeStream can only
+// pause and resume the packets already requested, so the running stream, if
+// any, is stopped like with StopStream, its channel is closed and the frames
+// not yet received are discarded. A new stream of newPacketIds is then
+// started like with Stream. If the new packets can't be streamed, the
+// running stream is left alone.
+func (roomba *Roomba) Restream(newPacketIds []constants.SensorCode) (<-chan [][]byte, error) {
+	req := &StreamRequest{packetIds: newPacketIds}
+	if _, err := req.Build(); err != nil {
+		return nil, err
+	}
+	stopped, err := roomba.stopActiveStream()
+	if err != nil {
+		return nil, err
+	}
+	if stopped {
+		// Discard the rest of the frame sent before the pause.
+		roomba.drain(streamDrainPeriod)
+	}
+	return roomba.StreamRequest(req)
+}
+
+// VerifyStreamSetup reads the number of packets the robot streams and returns
+// an error if it isn't the number of expected packets, e.g. to check that the
+// robot accepted a stream request.
+func (roomba *Roomba) VerifyStreamSetup(expected []constants.SensorCode) error {
+	n, err := roomba.Sensors(constants.SENSOR_NUM_STREAM_PACKETS)
+	if err != nil {
+		return err
+	}
+	if int(n[0]) != len(expected) {
+		return fmt.Errorf("robot streams %d packets, expected %d", n[0], len(expected))
+	}
+	return nil
+}
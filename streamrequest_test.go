@@ -0,0 +1,127 @@
+package roomba_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestStreamRequest(t *testing.T) {
+	req := new(roomba.StreamRequest).
+		Add(constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL).
+		Add(constants.SENSOR_VIRTUAL_WALL)
+	data, err := req.Build()
+	if err != nil {
+		t.Fatalf("error building request: %s", err)
+	}
+	if !bytes.Equal(data, []byte{2, 29, 13}) {
+		t.Errorf("request data is %v, expected [2 29 13]", data)
+	}
+	if n := req.FrameLength(); n != 8 {
+		t.Errorf("frame length is %d, expected 8", n)
+	}
+
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	out, err := r.StreamRequest(req)
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	frame := <-out
+	if len(frame) != 2 || !bytes.Equal(frame[0], []byte{2, 25}) || !bytes.Equal(frame[1], []byte{5}) {
+		t.Errorf("unexpected frame: %v", frame)
+	}
+	r.StopStream()
+	for range out {
+	}
+	rt.VerifyWritten(r, []byte{148, 2, 29, 13}, t)
+}
+
+func TestStreamRequestTooLarge(t *testing.T) {
+	// Each group 6 packet takes 53 bytes of a frame, so five of them don't fit.
+	req := new(roomba.StreamRequest)
+	for i := 0; i < 4; i++ {
+		req.Add(constants.SENSOR_GROUP_6)
+	}
+	if _, err := req.Build(); err != nil {
+		t.Errorf("error building request of %d bytes: %s", req.FrameLength(), err)
+	}
+	req.Add(constants.SENSOR_GROUP_6)
+	if _, err := req.Build(); !errors.Is(err, roomba.ErrFrameTooLarge) {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+	_, err := new(roomba.StreamRequest).Add(99).Build()
+	if !errors.Is(err, roomba.ErrUnknownPacket) {
+		t.Errorf("expected ErrUnknownPacket, got %v", err)
+	}
+}
+
+func TestVerifyStreamSetup(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	ids := []constants.SensorCode{constants.SENSOR_VIRTUAL_WALL, constants.SENSOR_WALL}
+	out, err := r.Stream(ids)
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	<-out
+	if err := r.VerifyStreamSetup(ids); err != nil {
+		t.Errorf("VerifyStreamSetup failed for the streamed packets: %s", err)
+	}
+	if err := r.VerifyStreamSetup(ids[:1]); err == nil {
+		t.Errorf("VerifyStreamSetup accepted 1 packet while 2 are streamed")
+	}
+	r.StopStream()
+	for range out {
+	}
+
+	rt.SetSensorValue(constants.SENSOR_NUM_STREAM_PACKETS, []byte{3})
+	if err := r.VerifyStreamSetup(ids); err == nil {
+		t.Errorf("VerifyStreamSetup accepted 2 packets while 3 are streamed")
+	}
+}
+
+func TestRestream(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	old, err := r.Stream([]constants.SensorCode{constants.SENSOR_OI_MODE})
+	if err != nil {
+		t.Fatalf("error starting stream: %s", err)
+	}
+	if frame := <-old; len(frame) != 1 || !bytes.Equal(frame[0], []byte{2}) {
+		t.Errorf("got frame %v, expected [[2]]", frame)
+	}
+
+	packetIds := []constants.SensorCode{constants.SENSOR_TEMPERATURE, constants.SENSOR_WALL}
+	out, err := r.Restream(packetIds)
+	if err != nil {
+		t.Fatalf("error restreaming: %s", err)
+	}
+	for range old {
+	}
+	for i := 0; i < 5; i++ {
+		frame := <-out
+		if len(frame) != 2 || !bytes.Equal(frame[0], []byte{25}) || !bytes.Equal(frame[1], []byte{35}) {
+			t.Errorf("got frame %v, expected [[25] [35]]", frame)
+		}
+	}
+	if err := r.VerifyStreamSetup(packetIds); err != nil {
+		t.Errorf("stream not set up: %s", err)
+	}
+	if _, err := r.Restream([]constants.SensorCode{99}); !errors.Is(err, roomba.ErrUnknownPacket) {
+		t.Errorf("expected ErrUnknownPacket, got %v", err)
+	}
+	// The running stream is kept after a failed Restream.
+	if frame, ok := <-out; !ok || len(frame) != 2 {
+		t.Errorf("got frame %v after a failed Restream, expected the running stream", frame)
+	}
+	r.StopStream()
+	for range out {
+	}
+}
@@ -0,0 +1,99 @@
+package testing
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/infinities-within/go-roomba"
+)
+
+// FakeTransport is an in-memory port for testing the command layer without
+// the simulator. It records the bytes written and answers commands with the
+// responses set with Respond. A response is readable as soon as the write
+// completing its command returns, so tests don't depend on scheduling.
+type FakeTransport struct {
+	mu        sync.Mutex
+	cond      *sync.Cond // Signaled when data becomes readable or on Close.
+	written   bytes.Buffer
+	pending   []byte // Written since the last answered command.
+	responses map[string][]byte
+	readable  bytes.Buffer
+	closed    bool
+}
+
+// NewFakeTransport returns a FakeTransport without responses.
+func NewFakeTransport() *FakeTransport {
+	f := &FakeTransport{responses: make(map[string][]byte)}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// MakeFakeRoomba returns a Roomba using a new FakeTransport as its port.
+func MakeFakeRoomba() (*roomba.Roomba, *FakeTransport) {
+	f := NewFakeTransport()
+	return &roomba.Roomba{S: f, StreamPaused: make(chan bool, 1)}, f
+}
+
+// Respond makes the transport send response whenever command, the opcode
+// followed by its data bytes, is written. Commands are matched at the end of
+// the bytes written since the last answered command, the longest first.
+func (f *FakeTransport) Respond(command []byte, response []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[string(command)] = append([]byte(nil), response...)
+}
+
+// Written returns all the bytes written so far.
+func (f *FakeTransport) Written() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]byte(nil), f.written.Bytes()...)
+}
+
+// Write records p and queues the response of the command it completes, if
+// any.
+func (f *FakeTransport) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, io.ErrClosedPipe
+	}
+	f.written.Write(p)
+	f.pending = append(f.pending, p...)
+	match := ""
+	for command := range f.responses {
+		if len(command) > len(match) && bytes.HasSuffix(f.pending, []byte(command)) {
+			match = command
+		}
+	}
+	if match != "" {
+		f.readable.Write(f.responses[match])
+		f.pending = nil
+		f.cond.Broadcast()
+	}
+	return len(p), nil
+}
+
+// Read returns the queued responses, blocking until there are some or the
+// transport is closed, like a port that the robot doesn't answer.
+func (f *FakeTransport) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.readable.Len() == 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if f.readable.Len() == 0 {
+		return 0, io.EOF
+	}
+	return f.readable.Read(p)
+}
+
+// Close makes pending and later reads return io.EOF and writes fail.
+func (f *FakeTransport) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	f.cond.Broadcast()
+	return nil
+}
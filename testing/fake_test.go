@@ -0,0 +1,42 @@
+package testing_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestFakeTransportDrive(t *testing.T) {
+	r, f := rt.MakeFakeRoomba()
+	defer f.Close()
+	if err := r.Drive(-200, 500); err != nil {
+		t.Fatalf("Drive failed: %s", err)
+	}
+	if w, expected := f.Written(), []byte{137, 255, 56, 1, 244}; !bytes.Equal(w, expected) {
+		t.Errorf("written % d, expected % d", w, expected)
+	}
+}
+
+func TestFakeTransportSensors(t *testing.T) {
+	r, f := rt.MakeFakeRoomba()
+	defer f.Close()
+	f.Respond([]byte{142, byte(constants.SENSOR_VOLTAGE)}, []byte{0x3e, 0x80})
+	f.Respond([]byte{142, byte(constants.SENSOR_OI_MODE)}, []byte{3})
+
+	r.Start()
+	for i := 0; i < 2; i++ {
+		v, err := r.Sensors(constants.SENSOR_VOLTAGE)
+		if err != nil || !bytes.Equal(v, []byte{0x3e, 0x80}) {
+			t.Errorf("voltage is %v, %v, expected [62 128]", v, err)
+		}
+	}
+	v, err := r.Sensors(constants.SENSOR_OI_MODE)
+	if err != nil || !bytes.Equal(v, []byte{3}) {
+		t.Errorf("OI mode is %v, %v, expected [3]", v, err)
+	}
+	if w, expected := f.Written(), []byte{128, 142, 22, 142, 22, 142, 35}; !bytes.Equal(w, expected) {
+		t.Errorf("written % d, expected % d", w, expected)
+	}
+}
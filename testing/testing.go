@@ -1,12 +1,14 @@
 package testing
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"testing"
 	"time"
 
 	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
 	"github.com/infinities-within/go-roomba/sim"
 )
 
@@ -29,11 +31,49 @@ func ClearTestRoomba() {
 	roombaSim = nil
 }
 
+// TestSimulator returns the simulator behind the Roomba returned by
+// MakeTestRoomba.
+func TestSimulator() *sim.RoombaSimulator {
+	return roombaSim
+}
+
+// SetSensorValue makes the simulator behind the test Roomba report the given
+// value for a sensor.
+func SetSensorValue(code constants.SensorCode, value []byte) {
+	roombaSim.SetSensor(code, value)
+}
+
+// SetSensorSequence makes the simulator behind the test Roomba report the
+// given values for a sensor, one per read.
+func SetSensorSequence(code constants.SensorCode, values [][]byte) {
+	roombaSim.SetSensorSequence(code, values)
+}
+
+// VerifySensorValue reads a sensor with r and checks that the value matches
+// expected.
+func VerifySensorValue(r *roomba.Roomba, code constants.SensorCode, expected []byte, t *testing.T) {
+	actual, err := r.Sensors(code)
+	if err != nil {
+		t.Errorf("failed reading sensor %d: %s", code, err)
+		return
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("sensor %d value: % d, expected: % d", code, actual, expected)
+	}
+}
+
+// VerifyMockedSensorValue sets the simulator's value for a sensor with
+// SetSensorValue and checks that r reads it back.
+func VerifyMockedSensorValue(r *roomba.Roomba, code constants.SensorCode, value []byte, t *testing.T) {
+	SetSensorValue(code, value)
+	VerifySensorValue(r, code, value, t)
+}
+
 func VerifyWritten(r *roomba.Roomba, expected []byte, t *testing.T) {
 	// TODO : Rewrite xa4a's async read/write thing into something that's consistent
 	time.Sleep(time.Millisecond * 100)
 	actual := make([]byte, len(expected))
-	roombaSim.ReadBytes.Read(actual)
+	copy(actual, roombaSim.ReadLog(len(expected)))
 	fmt.Println("Actual: ", actual)
 
 	if len(actual) != len(expected) {
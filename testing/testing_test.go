@@ -0,0 +1,24 @@
+package testing_test
+
+import (
+	"testing"
+
+	"github.com/infinities-within/go-roomba/constants"
+	"github.com/infinities-within/go-roomba/sim"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+func TestVerifySensorValue(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	rt.VerifySensorValue(r, constants.SENSOR_TEMPERATURE, []byte{25}, t)
+	rt.VerifyMockedSensorValue(r, constants.SENSOR_TEMPERATURE, []byte{60}, t)
+	rt.VerifyMockedSensorValue(r, constants.SENSOR_VOLTAGE, []byte{0x3e, 0x80}, t)
+	rt.ClearTestRoomba()
+
+	if v := sim.MockSensorValues[constants.SENSOR_TEMPERATURE]; len(v) != 1 || v[0] != 25 {
+		t.Errorf("default temperature mock changed: %v", v)
+	}
+	if _, ok := sim.MockSensorValues[constants.SENSOR_VOLTAGE]; ok {
+		t.Errorf("voltage mock added to the defaults")
+	}
+}
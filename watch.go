@@ -0,0 +1,83 @@
+// Callbacks for sensor events.
+
+package roomba
+
+import (
+	"context"
+	"log"
+
+	"github.com/infinities-within/go-roomba/constants"
+)
+
+// watchBumpsWheelDrops streams the bumps and wheel drops packet and calls fn
+// whenever event becomes true, that is when it was false for the previous
+// frame and is true now. It returns once the stream is started. fn is called
+// from a separate goroutine until ctx is done, which stops the stream.
+func (roomba *Roomba) watchBumpsWheelDrops(ctx context.Context, event func(BumpsWheelDrops) bool, fn func(BumpsWheelDrops)) error {
+	out, err := roomba.Stream([]constants.SensorCode{constants.SENSOR_BUMP_WHEELS_DROPS})
+	if err != nil {
+		return err
+	}
+	go func() {
+		wasHappening := false
+		for {
+			select {
+			case <-ctx.Done():
+				roomba.StopStream()
+				return
+			case frame, ok := <-out:
+				if !ok {
+					return
+				}
+				b := decodeBumpsWheelDrops(frame[0][0])
+				happening := event(b)
+				if happening && !wasHappening {
+					fn(b)
+				}
+				wasHappening = happening
+			}
+		}
+	}()
+	return nil
+}
+
+// OnBump streams the bumper state and calls fn whenever a bumper gets
+// pressed, that is when no bumper was pressed in the previous frame and one
+// is now. It returns once the stream is started. fn is called from a separate
+// goroutine until ctx is done, which stops the stream.
+func (roomba *Roomba) OnBump(ctx context.Context, fn func(BumpsWheelDrops)) error {
+	return roomba.watchBumpsWheelDrops(ctx, func(b BumpsWheelDrops) bool {
+		return b.BumpLeft || b.BumpRight
+	}, fn)
+}
+
+// GuardWheelDrop streams the wheel drop state and calls Stop whenever a wheel
+// drops, e.g. because the robot was picked up, as a watchdog alongside the
+// control loop. It returns once the stream is started and keeps guarding
+// until ctx is done, which stops the stream. Like OnBump, it uses the stream,
+// so it can't be combined with another one.
+func (roomba *Roomba) GuardWheelDrop(ctx context.Context) error {
+	return roomba.watchBumpsWheelDrops(ctx, func(b BumpsWheelDrops) bool {
+		return b.WheelDropLeft || b.WheelDropRight || b.WheelDropCaster
+	}, func(BumpsWheelDrops) {
+		log.Printf("wheel drop detected, stopping")
+		if err := roomba.Stop(); err != nil {
+			log.Printf("failed stopping after wheel drop: %v", err)
+		}
+	})
+}
+
+// OnChargingFault polls the charging state every chargePollInterval and calls
+// fn when it changes to ChargingFault. It returns immediately. fn is called
+// from a separate goroutine until ctx is done.
+func (roomba *Roomba) OnChargingFault(ctx context.Context, fn func()) {
+	wasFault := false
+	go roomba.poll(ctx, chargePollInterval, constants.SENSOR_CHARGING, func(state []byte) bool {
+		fault := ChargingState(state[0]) == ChargingFault
+		if fault && !wasFault {
+			fn()
+		}
+		wasFault = fault
+		return true
+	})
+}
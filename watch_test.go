@@ -0,0 +1,101 @@
+package roomba_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/infinities-within/go-roomba"
+	"github.com/infinities-within/go-roomba/constants"
+	rt "github.com/infinities-within/go-roomba/testing"
+)
+
+// singlePacketFrame returns a stream frame carrying a single packet.
+func singlePacketFrame(code constants.SensorCode, value ...byte) []byte {
+	return roomba.BuildStreamFrame(map[constants.SensorCode][]byte{code: value},
+		[]constants.SensorCode{code})
+}
+
+func TestOnBump(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+
+	var frames [][]byte
+	for _, b := range []byte{0, 1, 1, 0, 2, 3, 0} {
+		frames = append(frames, singlePacketFrame(constants.SENSOR_BUMP_WHEELS_DROPS, b))
+	}
+	rt.TestSimulator().LoadReplay(frames)
+	// The stream goes on with the simulated value once the replay ends.
+	rt.SetSensorValue(constants.SENSOR_BUMP_WHEELS_DROPS, []byte{0})
+
+	bumps := make(chan roomba.BumpsWheelDrops, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := r.OnBump(ctx, func(b roomba.BumpsWheelDrops) { bumps <- b }); err != nil {
+		t.Fatalf("OnBump failed: %s", err)
+	}
+
+	deadline := time.After(time.Second)
+	var got []roomba.BumpsWheelDrops
+	for len(got) < 2 {
+		select {
+		case b := <-bumps:
+			got = append(got, b)
+		case <-deadline:
+			t.Fatalf("expected 2 bumps, got %d", len(got))
+		}
+	}
+	if b := got[0]; !b.BumpRight || b.BumpLeft {
+		t.Errorf("first bump should be right only: %+v", b)
+	}
+	if b := got[1]; !b.BumpLeft || b.BumpRight {
+		t.Errorf("second bump should be left only: %+v", b)
+	}
+	// The rest of the replay holds or releases the bumper, no new bump.
+	select {
+	case b := <-bumps:
+		t.Errorf("unexpected third bump: %+v", b)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestGuardWheelDrop(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorValue(constants.SENSOR_BUMP_WHEELS_DROPS, []byte{0})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := r.GuardWheelDrop(ctx); err != nil {
+		t.Fatalf("GuardWheelDrop failed: %s", err)
+	}
+	r.Drive(100, 0)
+	time.Sleep(50 * time.Millisecond)
+	rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 100}, t)
+
+	rt.SetSensorValue(constants.SENSOR_BUMP_WHEELS_DROPS, []byte{8})
+	time.Sleep(100 * time.Millisecond)
+	rt.VerifySensorValue(r, constants.SENSOR_REQUESTED_VELOCITY, []byte{0, 0}, t)
+}
+
+func TestOnChargingFault(t *testing.T) {
+	r := rt.MakeTestRoomba()
+	defer rt.ClearTestRoomba()
+	rt.SetSensorSequence(constants.SENSOR_CHARGING, [][]byte{{2}, {2}, {5}})
+
+	ticks := make(chan time.Time)
+	defer roomba.SetTicker(ticks)()
+
+	faults := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.OnChargingFault(ctx, func() { faults <- struct{}{} })
+	// The fourth tick is taken once the third state was handled.
+	for i := 0; i < 4; i++ {
+		ticks <- time.Now()
+	}
+
+	if len(faults) != 1 {
+		t.Errorf("expected 1 charging fault, got %d", len(faults))
+	}
+}